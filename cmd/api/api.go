@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"reaktor-birdnest/internal/models"
+	"strconv"
+)
+
+type violationResponse struct {
+	Site            string       `json:"site"`
+	Pilot           models.Pilot `json:"pilot"`
+	ClosestDistance float64      `json:"closestDistance"`
+	// Episodes of the pilot's drone inside the zones since the violation
+	// started
+	Episodes []models.Episode `json:"episodes"`
+}
+
+// listViolations returns the current violations of every site, or of the
+// site given with ?site=, closer than ?maxDistance= meters when given.
+// Contact details are masked unless the caller is staff.
+func (app *application) listViolations(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.role(w, r)
+	if !ok {
+		return
+	}
+
+	sites, ok := app.selectSites(w, r)
+	if !ok {
+		return
+	}
+
+	maxDistance, ok := parseMaxDistance(w, r)
+	if !ok {
+		return
+	}
+
+	violations := make([]violationResponse, 0)
+	for _, s := range sites {
+		current, err := s.violations.Values()
+		if err != nil {
+			app.logger.Error("failed to decode violations", "site", s.cfg.ID, "err", err)
+			writeError(w, http.StatusInternalServerError, "violations of site "+s.cfg.ID+" failed to decode")
+			return
+		}
+		for _, v := range visibleTo(role, current) {
+			if maxDistance >= 0 && v.ClosestDistance > maxDistance {
+				continue
+			}
+			response := violationResponse{
+				Site:            s.cfg.ID,
+				Pilot:           v.Pilot,
+				ClosestDistance: v.ClosestDistance,
+				Episodes:        make([]models.Episode, 0),
+			}
+			if record, ok := app.history.Ongoing(s.cfg.ID, v.Pilot.PilotID); ok && record.Episodes != nil {
+				response.Episodes = record.Episodes
+			}
+			violations = append(violations, response)
+		}
+	}
+	writeJSON(w, http.StatusOK, violations)
+}
+
+// selectSites returns the site given with ?site=, or every site without it
+func (app *application) selectSites(w http.ResponseWriter, r *http.Request) ([]*site, bool) {
+	id := r.URL.Query().Get("site")
+	if len(id) == 0 {
+		return app.sites, true
+	}
+	s := app.site(id)
+	if s == nil {
+		writeError(w, http.StatusNotFound, "unknown site")
+		return nil, false
+	}
+	return []*site{s}, true
+}
+
+// parseMaxDistance returns ?maxDistance=, or -1 for no limit
+func parseMaxDistance(w http.ResponseWriter, r *http.Request) (float64, bool) {
+	raw := r.URL.Query().Get("maxDistance")
+	if len(raw) == 0 {
+		return -1, true
+	}
+	d, err := strconv.ParseFloat(raw, 64)
+	if err != nil || d < 0 {
+		writeError(w, http.StatusBadRequest, "maxDistance must be a non-negative number")
+		return 0, false
+	}
+	return d, true
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/tmaxmax/go-sse"
+	"html/template"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	reaktorbirdnest "reaktor-birdnest"
+	"reaktor-birdnest/internal/auth"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/geo"
+	"reaktor-birdnest/internal/heatmap"
+	"reaktor-birdnest/internal/history"
+	"reaktor-birdnest/internal/interfaces"
+	"reaktor-birdnest/internal/models"
+	"reaktor-birdnest/internal/notify"
+	"reaktor-birdnest/internal/persistence/datastore"
+	"reaktor-birdnest/internal/stats"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListViolationsMasksForPublic(t *testing.T) {
+	app, s := newApp()
+	app.auth = auth.New(config.Auth{Tokens: []string{"0123456789abcdef"}})
+	s.violations = datastore.New[models.Violation](time.Minute)
+	defer s.violations.Destroy()
+	app.sites = []*site{s}
+
+	s.violations.Upsert("SN-1", models.Violation{Pilot: testingPilot("Bob"), ClosestDistance: 40})
+	s.violations.Upsert("SN-2", models.Violation{Pilot: testingPilot("Alice"), ClosestDistance: 90})
+
+	tests := []struct {
+		name          string
+		url           string
+		authorization string
+		status        int
+		expected      []models.Pilot
+	}{
+		{"public", "/api/violations", "", http.StatusOK, []models.Pilot{testingPilot("Alice").Masked(), testingPilot("Bob").Masked()}},
+		{"staff", "/api/violations?maxDistance=50", "Bearer 0123456789abcdef", http.StatusOK, []models.Pilot{testingPilot("Bob")}},
+		{"invalid token", "/api/violations", "Bearer nope", http.StatusUnauthorized, nil},
+		{"unknown site", "/api/violations?site=nope", "", http.StatusNotFound, nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.url, nil)
+		if len(test.authorization) != 0 {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("Expected %s to respond %d, but was %d.", test.name, test.status, w.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var violations []violationResponse
+		json.NewDecoder(w.Body).Decode(&violations)
+		if len(violations) != len(test.expected) {
+			t.Errorf("Expected %s to see %d violations, but saw %v.", test.name, len(test.expected), violations)
+			continue
+		}
+		for i, v := range violations {
+			if v.Site != "test" || v.Pilot.FirstName != test.expected[i].FirstName || v.Pilot.Email != test.expected[i].Email {
+				t.Errorf("Expected %s to see %v, but saw %v.", test.name, test.expected[i], v.Pilot)
+			}
+		}
+	}
+}
+
+// undecodable fails to decode one of its violations like a corrupt value in
+// Redis
+type undecodable struct {
+	interfaces.Violations
+}
+
+func (u undecodable) Values() ([]models.Violation, error) {
+	return u.AsSlice(), errors.New("value SN-2: decode json: unexpected end of JSON input")
+}
+
+func TestUndecodableViolations(t *testing.T) {
+	app, s := newApp()
+	store := datastore.New[models.Violation](time.Minute)
+	defer store.Destroy()
+	s.violations = undecodable{store}
+	app.sites = []*site{s}
+	s.violations.Upsert("SN-1", models.Violation{Pilot: testingPilot("Bob"), ClosestDistance: 40})
+
+	for _, url := range []string{"/api/violations", "/api/violations.csv"} {
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "test failed to decode") {
+			t.Errorf("Expected %s to fail naming the site, but was %d: %s", url, w.Code, w.Body)
+		}
+	}
+}
+
+func TestViolationEpisodes(t *testing.T) {
+	app, s := newApp()
+	s.violations = datastore.New[models.Violation](time.Minute)
+	defer s.violations.Destroy()
+	s.tracker = notify.NewTracker("test", nil)
+	app.sites = []*site{s}
+
+	bob := models.Violation{Pilot: testingPilot("Bob"), ClosestDistance: 40}
+	s.violations.Upsert("SN-1", bob)
+	now := time.Now()
+	inside := []models.Drone{{SerialNumber: "SN-1", PositionX: 250000, PositionY: 210000}}
+	app.notify(s, observation{time: now, reported: true, drones: inside, changed: true, violations: []models.Violation{bob}}, nil)
+	app.notify(s, observation{time: now.Add(2 * time.Second), reported: true}, nil)
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, httptest.NewRequest("GET", "/api/violations", nil))
+	var violations []violationResponse
+	json.NewDecoder(w.Body).Decode(&violations)
+	if len(violations) != 1 || len(violations[0].Episodes) != 1 || violations[0].Episodes[0].ExitPoint == nil {
+		t.Fatalf("Expected Bob's violation to list the closed episode, but was %v.", violations)
+	}
+	if e := violations[0].Episodes[0]; e.Serial != "SN-1" || e.ClosestDistance != 40 {
+		t.Errorf("Expected the episode of SN-1 40 m from the origin, but was %v.", e)
+	}
+}
+
+func TestPilotStats(t *testing.T) {
+	app, s := newApp()
+	app.auth = auth.New(config.Auth{Tokens: []string{"0123456789abcdef"}})
+	app.tmpl = template.Must(template.ParseFS(reaktorbirdnest.TemplateFS, "ui/html/*"))
+	s.violations = datastore.New[models.Violation](time.Minute)
+	defer s.violations.Destroy()
+	app.sites = []*site{s}
+
+	// Bob's earlier violation is archived, the current one is not dispatched
+	// yet
+	bob := testingPilot("Bob")
+	start := time.Now().Add(-time.Hour)
+	app.history.Record(notify.Event{ID: "1", Type: notify.ViolationStarted, Site: "test", Time: start, Pilot: bob, ClosestDistance: 80})
+	app.history.Record(notify.Event{ID: "2", Type: notify.ViolationExpired, Site: "test", Time: start.Add(time.Minute), Pilot: bob, ClosestDistance: 80})
+	s.violations.Upsert("SN-1", models.Violation{Pilot: bob, ClosestDistance: 40})
+
+	get := func(url, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		if len(authorization) != 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+		return w
+	}
+
+	var pilots []stats.Pilot
+	json.NewDecoder(get("/api/stats/pilots", "").Body).Decode(&pilots)
+	if len(pilots) != 1 || pilots[0].Violations != 2 || pilots[0].ClosestDistance != 40 || pilots[0].Pilot.Email != bob.Masked().Email {
+		t.Errorf("Expected Bob masked with 2 violations, but was %v.", pilots)
+	}
+	json.NewDecoder(get("/api/stats/pilots?site=test&limit=1", "Bearer 0123456789abcdef").Body).Decode(&pilots)
+	if len(pilots) != 1 || pilots[0].Pilot.Email != bob.Email {
+		t.Errorf("Expected staff to see Bob's email, but was %v.", pilots)
+	}
+	if w := get("/api/stats/pilots?limit=-1", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a negative limit to be rejected, but was %d.", w.Code)
+	}
+	if w := get("/leaderboard", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<td>2</td>") {
+		t.Errorf("Expected the leaderboard to list Bob, but was %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestTimeseries(t *testing.T) {
+	app, s := newApp()
+	s.violations = datastore.New[models.Violation](time.Minute)
+	defer s.violations.Destroy()
+	s.tracker = notify.NewTracker("test", nil)
+	app.sites = []*site{s}
+
+	bob := models.Violation{Pilot: testingPilot("Bob"), ClosestDistance: 40}
+	s.violations.Upsert("SN-1", bob)
+	drones := []models.Drone{{SerialNumber: "SN-1", PositionX: 250000, PositionY: 210000}, {SerialNumber: "SN-2"}}
+	app.notify(s, observation{time: time.Now(), reported: true, drones: drones, changed: true, violations: []models.Violation{bob}}, nil)
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, httptest.NewRequest("GET", "/api/stats/timeseries?resolution=day", nil))
+	var series []timeseriesResponse
+	json.NewDecoder(w.Body).Decode(&series)
+	if len(series) != 1 || len(series[0].Buckets) != 1 {
+		t.Fatalf("Expected the current day of the site, but was %d: %v", w.Code, series)
+	}
+	if b := series[0].Buckets[0]; b.Drones != 2 || b.Violations != 1 || *b.MinDistance != 40 {
+		t.Errorf("Expected 2 drones, a violation and 40 m, but was %v.", b)
+	}
+
+	w = httptest.NewRecorder()
+	app.routes().ServeHTTP(w, httptest.NewRequest("GET", "/api/stats/timeseries?resolution=week", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown resolution to be rejected, but was %d.", w.Code)
+	}
+}
+
+func TestDrones(t *testing.T) {
+	app, s := newApp()
+	app.auth = auth.New(config.Auth{Tokens: []string{"0123456789abcdef"}})
+	s.violations = datastore.New[models.Violation](time.Minute)
+	defer s.violations.Destroy()
+	app.sites = []*site{s}
+	now := time.Now()
+	s.devices.Observe([]models.Drone{{SerialNumber: "SN-1", Mac: "aa:aa"}, {SerialNumber: "SN-2", Mac: "bb:bb"}}, now)
+	s.devices.Observe([]models.Drone{{SerialNumber: "SN-1", Mac: "cc:cc"}, {SerialNumber: "SN-2", Mac: "bb:bb"}}, now.Add(time.Second))
+
+	bob := testingPilot("Bob")
+	app.history.Record(notify.Event{ID: "1", Type: notify.ViolationStarted, Site: "test", Time: now, Pilot: bob})
+	app.history.Record(notify.Event{ID: "2", Type: notify.ZoneExited, Site: "test", Time: now, Pilot: bob,
+		Episode: &models.Episode{ID: "E-1", Serial: "SN-1", Manufacturer: "DJI", Model: "Mavic", Duration: 12}})
+
+	get := func(url, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		if len(authorization) != 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/api/drones", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the addresses to be for staff only, but was %d.", w.Code)
+	}
+	var drones []deviceResponse
+	json.NewDecoder(get("/api/drones?changed=true", "Bearer 0123456789abcdef").Body).Decode(&drones)
+	if len(drones) != 1 || drones[0].Serial != "SN-1" || drones[0].Site != "test" {
+		t.Errorf("Expected SN-1 to be flagged, but was %v.", drones)
+	}
+
+	var breakdown []stats.Breakdown
+	json.NewDecoder(get("/api/stats/drones?by=model", "").Body).Decode(&breakdown)
+	if len(breakdown) != 1 || breakdown[0].Model != "Mavic" || breakdown[0].Violations != 1 || breakdown[0].TimeInZone != 12 {
+		t.Errorf("Expected a Mavic violation, but was %v.", breakdown)
+	}
+	if w := get("/api/stats/drones?by=colour", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown grouping to be rejected, but was %d.", w.Code)
+	}
+}
+
+func TestExports(t *testing.T) {
+	app, s := newApp()
+	app.auth = auth.New(config.Auth{Tokens: []string{"0123456789abcdef"}})
+	s.violations = datastore.New[models.Violation](time.Minute)
+	defer s.violations.Destroy()
+	app.sites = []*site{s}
+
+	bob, alice := testingPilot("Bob"), testingPilot("Alice")
+	alice.PilotID = "456"
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	app.history.Record(notify.Event{ID: "1", Type: notify.ViolationStarted, Site: "test", Time: start, Pilot: alice, ClosestDistance: 90})
+	app.history.Record(notify.Event{ID: "2", Type: notify.ViolationExpired, Site: "test", Time: start.Add(time.Minute), Pilot: alice, ClosestDistance: 90})
+	app.history.Record(notify.Event{ID: "3", Type: notify.ViolationStarted, Site: "test", Time: start.Add(48 * time.Hour), Pilot: bob, ClosestDistance: 40})
+	app.history.Record(notify.Event{ID: "4", Type: notify.ZoneExited, Site: "test", Pilot: bob, Episode: &models.Episode{
+		ID: "E-1", ClosestDistance: 40, ClosestPoint: models.Point{X: 250, Y: 210}, Duration: 12,
+	}})
+	s.violations.Upsert("SN-1", models.Violation{Pilot: bob, ClosestDistance: 40})
+
+	get := func(url, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		if len(authorization) != 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/api/violations.csv?maxDistance=50", "")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 2 || rows[1][0] != "3" || rows[1][5] != bob.Masked().Email || rows[1][8] != "" || rows[1][12] != "1" || rows[1][13] != "12" {
+		t.Errorf("Expected Bob's masked violation with an episode, but was %v with %v.", rows, err)
+	}
+	if w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("Expected a CSV file, but was %s.", w.Header().Get("Content-Type"))
+	}
+
+	if w := get("/api/violations.geojson", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected GeoJSON to need the site's origin, but was %d.", w.Code)
+	}
+	app.cfg.Load().Sites[0].Origin = &geo.Reference{Lat: 60, Lon: 25}
+	var collection struct {
+		Features []struct {
+			Geometry *struct {
+				Coordinates [2]float64 `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(get("/api/history.geojson", "").Body).Decode(&collection); err != nil || len(collection.Features) != 2 {
+		t.Fatalf("Expected a feature per record, but was %v with %v.", collection, err)
+	}
+	if g := collection.Features[1].Geometry; g == nil || math.Abs(g.Coordinates[0]-25.004492) > 1e-6 || math.Abs(g.Coordinates[1]-60.001886) > 1e-6 {
+		t.Errorf("Expected Bob's closest approach in WGS84, but was %v.", g)
+	}
+	if collection.Features[0].Geometry != nil {
+		t.Errorf("Expected no geometry without episodes, but was %v.", collection.Features[0].Geometry)
+	}
+
+	rows, err = csv.NewReader(get("/api/history.csv?maxDistance=50", "").Body).ReadAll()
+	if err != nil || len(rows) != 2 || rows[1][8] != "60.001886" || rows[1][9] != "25.004492" {
+		t.Errorf("Expected Bob's closest approach in WGS84, but was %v with %v.", rows, err)
+	}
+
+	w = get("/api/history.jsonl?from=2023-01-02", "Bearer 0123456789abcdef")
+	var record history.Record
+	decoder := json.NewDecoder(w.Body)
+	if err := decoder.Decode(&record); err != nil || record.Pilot.Email != bob.Email || decoder.More() {
+		t.Errorf("Expected only Bob's violation unmasked, but was %v with %v.", record, err)
+	}
+	if w := get("/api/history.csv?to=yesterday", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid time to be rejected, but was %d.", w.Code)
+	}
+}
+
+func TestErasePilot(t *testing.T) {
+	app, s := newApp()
+	cfg := app.cfg.Load()
+	cfg.HTTP.AdminToken = "admin-token"
+	cfg.History.AuditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+	app.tmpl = template.Must(template.ParseFS(reaktorbirdnest.TemplateFS, "ui/html/*"))
+	s.sseHandler = sse.NewServer()
+	s.homepages = make(map[auth.Role][]byte)
+	s.violations = datastore.New[models.Violation](time.Minute)
+	defer s.violations.Destroy()
+	app.sites = []*site{s}
+
+	bob, alice := testingPilot("Bob"), testingPilot("Alice")
+	bob.PilotID = "456"
+	s.violations.Upsert("SN-1", models.Violation{Pilot: bob, ClosestDistance: 40})
+	s.violations.Upsert("SN-2", models.Violation{Pilot: alice, ClosestDistance: 90})
+	s.tracker = notify.NewTracker("test", nil)
+	s.tracker.Update(s.violations.AsSlice(), time.Now())
+	app.history.Record(notify.Event{ID: "1", Type: notify.ViolationStarted, Site: "test", Time: time.Now(), Pilot: bob})
+
+	r := httptest.NewRequest("POST", "/admin/erasure", strings.NewReader(`{"email":"`+strings.ToUpper(bob.Email)+`"}`))
+	r.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the erasure to succeed, but was %d: %s", w.Code, w.Body)
+	}
+
+	var response erasureResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	if response.Erased["violations"] != 1 || response.Erased["history"] != 1 {
+		t.Errorf("Expected a violation and a history record to be erased, but was %v.", response.Erased)
+	}
+	if violations := s.violations.AsSlice(); len(violations) != 1 || violations[0].Pilot.PilotID != alice.PilotID {
+		t.Errorf("Expected only Alice to be left, but was %v.", violations)
+	}
+	if home := string(s.homepages[auth.Staff]); strings.Contains(home, bob.Email) || !strings.Contains(home, alice.Email) {
+		t.Errorf("Expected the homepage to be rendered without Bob.")
+	}
+
+	audit, _ := os.ReadFile(cfg.History.AuditLog)
+	if strings.Contains(string(audit), bob.Email) || !strings.Contains(string(audit), history.HashSubject(strings.ToLower(bob.Email))) {
+		t.Errorf("Expected the audit log to record the hash of the email only, but was %s.", audit)
+	}
+}
+
+func TestMapPositions(t *testing.T) {
+	app, s := newApp()
+	app.tmpl = template.Must(template.ParseFS(reaktorbirdnest.TemplateFS, "ui/html/*"))
+	s.sseHandler = sse.NewServer()
+	s.violations = datastore.New[models.Violation](time.Minute)
+	defer s.violations.Destroy()
+	app.sites = []*site{s}
+	s.violations.Upsert("SN-1", models.Violation{Pilot: testingPilot("Bob"), ClosestDistance: 40})
+
+	now := time.Now()
+	app.processPositions(context.Background(), s, &snapshot{
+		Time: now,
+		Drones: []dronePosition{
+			{Drone: models.Drone{SerialNumber: "SN-1", PositionX: 250040.4, PositionY: 249990}},
+			{Drone: models.Drone{SerialNumber: "SN-2", PositionX: 10000, PositionY: 20000}},
+		},
+	}, map[string]bool{"SN-1": true})
+	expected := `{"t":` + strconv.FormatInt(now.UnixMilli(), 10) + `,"d":[["SN-1",250,250,1],["SN-2",10,20,0]]}`
+	if actual, _ := json.Marshal(s.positions); string(actual) != expected {
+		t.Errorf("Expected positions %s, but was %s.", expected, actual)
+	}
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, httptest.NewRequest("GET", "/sites/test/map", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `["SN-2",10,20,0]`) || !strings.Contains(w.Body.String(), `"radius":100`) {
+		t.Errorf("Expected the map to show the zone and the latest positions, but was %d: %s", w.Code, w.Body)
+	}
+
+	// Sites with an origin locate the drones on the globe
+	drones := locateDrones(&geo.Reference{Lat: 60, Lon: 25}, []models.Drone{{SerialNumber: "SN-2", PositionX: 10000, PositionY: 20000}})
+	app.processPositions(context.Background(), s, &snapshot{Time: now, Drones: []dronePosition{{Drone: drones[0]}}}, nil)
+	if actual, _ := json.Marshal(s.positions.Drones); string(actual) != `[["SN-2",10,20,0,null,60.00018,25.00018]]` {
+		t.Errorf("Expected the latitude and longitude after the ETA, but was %s.", actual)
+	}
+}
+
+func TestServeHeatmap(t *testing.T) {
+	app, s := newApp()
+	app.sites = []*site{s}
+	s.heatmap.Add([]models.Zone{zone}, []models.Drone{{SerialNumber: "SN-1", PositionX: 250000, PositionY: 250000}}, time.Now())
+
+	get := func(url, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		if len(accept) != 0 {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+		return w
+	}
+
+	var grid heatmap.Grid
+	w := get("/sites/test/heatmap?window=15m", "")
+	json.NewDecoder(w.Body).Decode(&grid)
+	if w.Code != http.StatusOK || len(grid.Drones) != 50 || grid.Drones[25][25] != 1 || grid.Incursions[25][25] != 1 {
+		t.Errorf("Expected a 50 × 50 grid with the drone in the middle, but was %d.", w.Code)
+	}
+	w = get("/sites/test/heatmap?layer=incursions", "image/png")
+	if img, err := png.Decode(w.Body); err != nil || w.Header().Get("Content-Type") != "image/png" || img.Bounds().Dx() != 50 {
+		t.Errorf("Expected a 50 × 50 PNG, but was %v.", err)
+	}
+	if w := get("/sites/test/heatmap?window=48h", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a window beyond the retention to be rejected, but was %d.", w.Code)
+	}
+
+	app.cfg.Load().Sites[0].Origin = &geo.Reference{Lat: 60, Lon: 25}
+	var located heatmapResponse
+	json.NewDecoder(get("/sites/test/heatmap", "").Body).Decode(&located)
+	if c := located.Corners; len(c) != 4 || c[3] != [2]float64{25, 60} || math.Abs(c[0][1]-60.004492) > 1e-6 || math.Abs(c[2][0]-25.008983) > 1e-6 {
+		t.Errorf("Expected the corners of the sensor area, but was %v.", c)
+	}
+}
+
+func TestLatestSnapshot(t *testing.T) {
+	app, s := newApp()
+	app.auth = auth.New(config.Auth{Tokens: []string{"0123456789abcdef"}})
+	s.sseHandler = sse.NewServer()
+	app.sites = []*site{s}
+
+	get := func(authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/snapshot/latest", nil)
+		if len(authorization) != 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+		return w
+	}
+	if w := get("Bearer 0123456789abcdef"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected no snapshot before the first report, but was %d.", w.Code)
+	}
+
+	// Zone origin at 250 m, 250 m with a radius of 100 m
+	now := time.Now()
+	app.processSnapshot(context.Background(), s, observation{time: now, reported: true, drones: []models.Drone{
+		{SerialNumber: "SN-1", PositionX: 100000, PositionY: 250000},
+	}})
+	app.processSnapshot(context.Background(), s, observation{time: now.Add(2 * time.Second), reported: true, drones: []models.Drone{
+		{SerialNumber: "SN-1", PositionX: 110000, PositionY: 240000},
+		{SerialNumber: "SN-2", PositionX: 250000, PositionY: 200000},
+	}})
+
+	if w := get(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the snapshot to be for staff only, but was %d.", w.Code)
+	}
+	w := get("Bearer 0123456789abcdef")
+	var snap snapshot
+	json.NewDecoder(w.Body).Decode(&snap)
+	if w.Code != http.StatusOK || len(snap.Drones) != 2 {
+		t.Fatalf("Expected the latest snapshot with 2 drones, but was %d: %v", w.Code, snap)
+	}
+	if d := snap.Drones[0]; d.Heading == nil || math.Abs(*d.Heading-135) > 1e-9 || math.Abs(d.BoundaryDistance-40.36) > 0.01 {
+		t.Errorf("Expected SN-1 to head 135° 40.36 m from the zone, but was %v %v.", d.Heading, d.BoundaryDistance)
+	}
+	if d := snap.Drones[1]; d.Heading != nil || d.BoundaryDistance != -50 {
+		t.Errorf("Expected SN-2 to have no heading 50 m inside the zone, but was %v %v.", d.Heading, d.BoundaryDistance)
+	}
+}
+
+func TestPredictIncursion(t *testing.T) {
+	app, s := newApp()
+	s.sseHandler = sse.NewServer()
+
+	// Heading north at 5 m/s, 40 m south of the zone's edge
+	now := time.Now()
+	report := func(after time.Duration, y float64) (*snapshot, []dronePosition) {
+		return app.processSnapshot(context.Background(), s, observation{time: now.Add(after), reported: true, drones: []models.Drone{
+			{SerialNumber: "SN-1", PositionX: 250000, PositionY: y},
+			{SerialNumber: "SN-2", PositionX: 0, PositionY: y},
+		}})
+	}
+	report(0, 100000)
+	snap, predicted := report(2*time.Second, 110000)
+	if len(predicted) != 1 || predicted[0].SerialNumber != "SN-1" || math.Abs(*predicted[0].ETA-8) > 1e-9 {
+		t.Fatalf("Expected SN-1 to enter the zone in 8 s, but was %v.", predicted)
+	}
+	if snap.Drones[1].ETA != nil {
+		t.Errorf("Expected SN-2 to pass by the zone, but was %v s.", *snap.Drones[1].ETA)
+	}
+	if _, predicted := report(4*time.Second, 120000); len(predicted) != 0 {
+		t.Errorf("Expected an approach to be predicted once, but was %v.", predicted)
+	}
+
+	if eta, ok := zone.Incursion(250, 300, 0, -5); ok {
+		t.Errorf("Expected no incursion for a drone inside the zone, but was %v s.", eta)
+	}
+	if eta, ok := zone.Incursion(250, 0, 0, 5); !ok || eta != 30 {
+		t.Errorf("Expected an incursion in 30 s, but was %v %v.", eta, ok)
+	}
+}
+
+func TestMaskedPilot(t *testing.T) {
+	masked := models.Pilot{PilotID: "P-1", FirstName: "Örjan", LastName: "Tester", Email: "orjan@example.com", PhoneNumber: "+358401234567"}.Masked()
+
+	if masked.FirstName != "Ö." || masked.LastName != "T." {
+		t.Errorf("Expected initials, but was %s %s.", masked.FirstName, masked.LastName)
+	}
+	if masked.Email != "o***@example.com" {
+		t.Errorf("Expected a partial email, but was %s.", masked.Email)
+	}
+	if masked.PhoneNumber != "***********67" {
+		t.Errorf("Expected all but the last two digits to be masked, but was %s.", masked.PhoneNumber)
+	}
+	if masked.PilotID != "P-1" {
+		t.Errorf("Expected the pilot id to be kept, but was %s.", masked.PilotID)
+	}
+}
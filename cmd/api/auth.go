@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/tmaxmax/go-sse"
+	"net/http"
+	"reaktor-birdnest/internal/auth"
+	"time"
+)
+
+// role authenticates the request, answering 401 to invalid credentials
+func (app *application) role(w http.ResponseWriter, r *http.Request) (auth.Role, bool) {
+	role, err := app.auth.Role(r)
+	if err != nil {
+		app.challenge(w)
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return role, false
+	}
+	return role, true
+}
+
+func (app *application) challenge(w http.ResponseWriter) {
+	if app.auth.BasicAuth() {
+		w.Header().Set("WWW-Authenticate", `Basic realm="birdnest", charset="UTF-8"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+}
+
+// login asks the browser for basic auth credentials, which it then sends
+// with every request including the event stream
+func (app *application) login(w http.ResponseWriter, r *http.Request) {
+	if !app.auth.BasicAuth() {
+		http.NotFound(w, r)
+		return
+	}
+	if role, err := app.auth.Role(r); err != nil || role != auth.Staff {
+		app.challenge(w)
+		writeError(w, http.StatusUnauthorized, "log in to see contact details")
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// serveEvents is sse.Server.ServeHTTP subscribed to a single topic, e.g. the
+// role's, sending at most one event per interval when it is positive
+func (app *application) serveEvents(w http.ResponseWriter, r *http.Request, s *site, topic string, interval time.Duration) {
+	conn, err := sse.Upgrade(w)
+	if err != nil {
+		http.Error(w, "Server-sent events unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	id := sse.EventID{}
+	if h := r.Header.Get("Last-Event-Id"); len(h) != 0 {
+		id, _ = sse.NewEventID(h)
+	}
+
+	var sent time.Time
+	send := func(m *sse.Message) bool {
+		if interval > 0 && time.Since(sent) < interval {
+			return true
+		}
+		sent = time.Now()
+		if err := conn.Send(m); err != nil {
+			app.logger.Debug("event stream closed", "site", s.cfg.ID, "err", err)
+			return false
+		}
+		return true
+	}
+	if err := s.sseHandler.Subscribe(r.Context(), send, id, topic); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
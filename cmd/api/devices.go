@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"reaktor-birdnest/internal/devices"
+	"reaktor-birdnest/internal/stats"
+)
+
+type deviceResponse struct {
+	Site string `json:"site"`
+	devices.Device
+}
+
+// listDrones returns the drones of every site, or of the site given with
+// ?site=, with their addresses. ?changed=true returns only those whose MAC
+// or IP address changed between sightings. Staff only.
+func (app *application) listDrones(w http.ResponseWriter, r *http.Request) {
+	if !app.requireStaff(w, r) {
+		return
+	}
+	sites, ok := app.selectSites(w, r)
+	if !ok {
+		return
+	}
+	changedOnly := r.URL.Query().Get("changed") == "true"
+
+	drones := make([]deviceResponse, 0)
+	for _, s := range sites {
+		for _, d := range s.devices.List() {
+			if changedOnly && !d.Changed {
+				continue
+			}
+			drones = append(drones, deviceResponse{Site: s.cfg.ID, Device: d})
+		}
+	}
+	writeJSON(w, http.StatusOK, drones)
+}
+
+// droneStats returns the violations of every site, or of the site given with
+// ?site=, broken down by the ?by= manufacturer, model (the default) or
+// firmware of the drones
+func (app *application) droneStats(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.role(w, r); !ok {
+		return
+	}
+	sites, ok := app.selectSites(w, r)
+	if !ok {
+		return
+	}
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "":
+		by = stats.ByModel
+	case stats.ByManufacturer, stats.ByModel, stats.ByFirmware:
+	default:
+		writeError(w, http.StatusBadRequest, "by must be manufacturer, model or firmware")
+		return
+	}
+	writeJSON(w, http.StatusOK, stats.Drones(app.offences(sites), by))
+}
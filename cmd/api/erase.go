@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/history"
+	"reaktor-birdnest/internal/models"
+	"reaktor-birdnest/internal/notify"
+	"strings"
+	"time"
+)
+
+// erasureRequest names the pilot to erase by exactly one identifier
+type erasureRequest struct {
+	PilotID string `json:"pilotId,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type erasureResponse struct {
+	Erased map[string]int `json:"erased"`
+}
+
+// subject returns the kind of identifier, the identifier and the pilots it
+// matches. Emails match regardless of case.
+func (req erasureRequest) subject() (string, string, func(models.Pilot) bool, error) {
+	switch {
+	case len(req.PilotID) != 0 && len(req.Email) != 0:
+		return "", "", nil, errors.New("give either pilotId or email, not both")
+	case len(req.PilotID) != 0:
+		return "pilotId", req.PilotID, func(p models.Pilot) bool {
+			return p.PilotID == req.PilotID
+		}, nil
+	case len(req.Email) != 0:
+		email := strings.ToLower(strings.TrimSpace(req.Email))
+		return "email", email, func(p models.Pilot) bool {
+			return strings.EqualFold(p.Email, email)
+		}, nil
+	}
+	return "", "", nil, errors.New("pilotId or email must be set")
+}
+
+// eraseHandler erases a pilot's data on request and records the erasure in
+// the audit log
+func (app *application) eraseHandler(w http.ResponseWriter, r *http.Request) {
+	var req erasureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	subject, identifier, match, err := req.subject()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	erased, err := app.erase(r.Context(), match)
+	entry := history.AuditEntry{
+		Time:        time.Now(),
+		Actor:       "admin",
+		Remote:      r.RemoteAddr,
+		Subject:     subject,
+		SubjectHash: history.HashSubject(identifier),
+		Reason:      req.Reason,
+		Erased:      erased,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if auditErr := app.audit(entry); auditErr != nil {
+		err = errors.Join(err, auditErr)
+	}
+	if err != nil {
+		app.logger.Error("erasure incomplete", "subject", subject, "err", err)
+		writeError(w, http.StatusInternalServerError, "erasure incomplete, see the server logs")
+		return
+	}
+	writeJSON(w, http.StatusOK, erasureResponse{Erased: erased})
+}
+
+// erase removes the matching pilots from the violations of every site, the
+// history and the notification sinks. It returns how many items were removed
+// from each.
+func (app *application) erase(ctx context.Context, match func(models.Pilot) bool) (map[string]int, error) {
+	erased := map[string]int{"violations": 0, "history": 0, "notifications": 0}
+	now := time.Now()
+
+	var events []notify.Event
+	var changed []*site
+	for _, s := range app.sites {
+		deleted := s.violations.DeleteFunc(func(v models.Violation) bool {
+			return match(v.Pilot)
+		})
+		erased["violations"] += deleted
+		// Forgotten by the tracker so that they are not expired later
+		siteEvents := s.tracker.Erase(match, now)
+		events = append(events, siteEvents...)
+		if deleted != 0 || len(siteEvents) != 0 {
+			changed = append(changed, s)
+		}
+	}
+
+	n, err := app.history.Erase(match)
+	erased["history"] = n
+	// Before sending the erased events, which match by pilot id
+	erased["notifications"] = app.notifier.Erase(match)
+	for _, event := range events {
+		app.notifier.Notify(event)
+	}
+
+	for _, s := range changed {
+		app.processViolations(ctx, s, s.violations.AsSlice())
+	}
+	return erased, err
+}
+
+// audit logs the entry and appends it to the audit log when configured
+func (app *application) audit(entry history.AuditEntry) error {
+	app.logger.Info("pilot data erased", "subject", entry.Subject, "subjectHash", entry.SubjectHash, "erased", entry.Erased)
+	path := app.cfg.Load().History.AuditLog
+	if len(path) == 0 {
+		return nil
+	}
+	return history.AppendAudit(path, entry)
+}
+
+// enforceRetention anonymises the history records older than the retention
+// at start and every hour after
+func (app *application) enforceRetention() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if days := app.cfg.Load().History.RetentionDays; days > 0 {
+			before := time.Now().AddDate(0, 0, -days)
+			n, err := app.history.Anonymise(before)
+			if err != nil {
+				app.logger.Error("failed to anonymise history", "err", err)
+			} else if n != 0 {
+				app.logger.Info("history anonymised", "records", n, "before", before)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// eraseCommand implements "api erase", which asks a running server to erase
+// a pilot's data through the admin endpoint
+func eraseCommand(args []string) int {
+	flags := flag.NewFlagSet("erase", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv("BIRDNEST_CONFIG"), "Path to the YAML configuration file")
+	server := flags.String("server", "", "URL of the server, http://localhost:<http.port> by default")
+	var req erasureRequest
+	flags.StringVar(&req.PilotID, "pilot-id", "", "Pilot id to erase")
+	flags.StringVar(&req.Email, "email", "", "Email of the pilot to erase")
+	flags.StringVar(&req.Reason, "reason", "", "Reason recorded in the audit log, e.g. a ticket number")
+	// Deployments still set up by flags pass the same ones as to the server
+	legacy := legacyFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	overrides, deprecated := legacy()
+	if _, _, _, err := req.subject(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath, overrides)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		return 1
+	}
+	warnDeprecated(deprecated)
+	if len(cfg.HTTP.AdminToken) == 0 {
+		fmt.Fprintln(os.Stderr, "http.adminToken must be set to erase data")
+		return 1
+	}
+	if len(*server) == 0 {
+		*server = fmt.Sprintf("http://localhost:%d", cfg.HTTP.Port)
+	}
+
+	body, _ := json.Marshal(req)
+	r, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*server, "/")+"/admin/erasure", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+cfg.HTTP.AdminToken)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	io.Copy(os.Stdout, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "erasure failed:", resp.Status)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reaktor-birdnest/internal/auth"
+	"reaktor-birdnest/internal/geo"
+	"reaktor-birdnest/internal/history"
+	"reaktor-birdnest/internal/models"
+	"strconv"
+	"time"
+)
+
+// exportFormats are the content types of the exports by file extension
+var exportFormats = map[string]string{
+	"csv":     "text/csv; charset=utf-8",
+	"jsonl":   "application/jsonl",
+	"geojson": "application/geo+json",
+}
+
+// historyPageSize is how many records are copied from the archive at a time
+// while streaming an export
+const historyPageSize = 500
+
+// exportViolations serves the current violations as a file, with the
+// filters of listViolations
+func (app *application) exportViolations(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, ok := app.role(w, r)
+		if !ok {
+			return
+		}
+		sites, ok := app.selectSites(w, r)
+		if !ok {
+			return
+		}
+		maxDistance, ok := parseMaxDistance(w, r)
+		if !ok {
+			return
+		}
+		origins, ok := app.origins(w, format, sites)
+		if !ok {
+			return
+		}
+
+		current := make([][]models.Violation, len(sites))
+		for i, s := range sites {
+			var err error
+			if current[i], err = s.violations.Values(); err != nil {
+				app.logger.Error("failed to decode violations", "site", s.cfg.ID, "err", err)
+				writeError(w, http.StatusInternalServerError, "violations of site "+s.cfg.ID+" failed to decode")
+				return
+			}
+		}
+
+		out := newRecordWriter(w, "violations", format, origins)
+		for i, s := range sites {
+			for _, v := range visibleTo(role, current[i]) {
+				if maxDistance >= 0 && v.ClosestDistance > maxDistance {
+					continue
+				}
+				record, _ := app.history.Ongoing(s.cfg.ID, v.Pilot.PilotID)
+				record.Site, record.Pilot, record.ClosestDistance = s.cfg.ID, v.Pilot, v.ClosestDistance
+				if err := out.write(record); err != nil {
+					return
+				}
+			}
+		}
+		out.close()
+	}
+}
+
+// exportHistory streams the archived violations of every site, or of the
+// site given with ?site=, closer than ?maxDistance= meters and started
+// between ?from= and ?to= (RFC 3339 or a date) when given. Contact details
+// are masked unless the caller is staff.
+func (app *application) exportHistory(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, ok := app.role(w, r)
+		if !ok {
+			return
+		}
+		sites, ok := app.selectSites(w, r)
+		if !ok {
+			return
+		}
+		maxDistance, ok := parseMaxDistance(w, r)
+		if !ok {
+			return
+		}
+		from, ok := parseTime(w, r, "from")
+		if !ok {
+			return
+		}
+		to, ok := parseTime(w, r, "to")
+		if !ok {
+			return
+		}
+		origins, ok := app.origins(w, format, sites)
+		if !ok {
+			return
+		}
+		ids := make(map[string]bool, len(sites))
+		for _, s := range sites {
+			ids[s.cfg.ID] = true
+		}
+
+		out := newRecordWriter(w, "history", format, origins)
+		flush := http.NewResponseController(w).Flush
+		for offset := 0; ; offset += historyPageSize {
+			page := app.history.Page(offset, historyPageSize)
+			if len(page) == 0 {
+				break
+			}
+			for _, record := range page {
+				if !ids[record.Site] ||
+					maxDistance >= 0 && record.ClosestDistance > maxDistance ||
+					!from.IsZero() && record.Start.Before(from) ||
+					!to.IsZero() && !record.Start.Before(to) {
+					continue
+				}
+				if role != auth.Staff && !record.Anonymised {
+					record.Pilot = record.Pilot.Masked()
+				}
+				if err := out.write(record); err != nil {
+					return
+				}
+			}
+			flush()
+		}
+		out.close()
+	}
+}
+
+// origins returns the origins of the sites by id, answering 404 for GeoJSON
+// when a site has none
+func (app *application) origins(w http.ResponseWriter, format string, sites []*site) (map[string]*geo.Reference, bool) {
+	cfg := app.cfg.Load()
+	origins := make(map[string]*geo.Reference, len(sites))
+	for _, s := range sites {
+		sc, _ := cfg.Site(s.cfg.ID)
+		if sc.Origin == nil && format == "geojson" {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("site %s has no origin to convert positions to WGS84", s.cfg.ID))
+			return nil, false
+		}
+		origins[s.cfg.ID] = sc.Origin
+	}
+	return origins, true
+}
+
+// parseTime returns the time of the query parameter, zero when not given
+func parseTime(w http.ResponseWriter, r *http.Request, name string) (time.Time, bool) {
+	raw := r.URL.Query().Get(name)
+	if len(raw) == 0 {
+		return time.Time{}, true
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, true
+		}
+	}
+	writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 time or a date like 2023-01-31")
+	return time.Time{}, false
+}
+
+// recordWriter writes records to an export as they come
+type recordWriter interface {
+	write(r history.Record) error
+	close() error
+}
+
+// newRecordWriter sets the headers of the export named name and starts
+// writing it in the format
+func newRecordWriter(w http.ResponseWriter, name, format string, origins map[string]*geo.Reference) recordWriter {
+	w.Header().Set("Content-Type", exportFormats[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	switch format {
+	case "csv":
+		c := &csvWriter{w: csv.NewWriter(w), origins: origins}
+		c.w.Write(csvHeader)
+		return c
+	case "geojson":
+		io.WriteString(w, `{"type":"FeatureCollection","features":[`)
+		return &geojsonWriter{w: w, origins: origins}
+	default:
+		return &jsonlWriter{json.NewEncoder(w)}
+	}
+}
+
+var csvHeader = []string{
+	"id", "site", "pilotId", "firstName", "lastName", "email", "phoneNumber",
+	"closestDistance", "closestLat", "closestLon", "start", "end", "episodes", "timeInZone", "anonymised",
+}
+
+// csvWriter writes a row per record, with the latitude and longitude of its
+// closest approach when known
+type csvWriter struct {
+	w       *csv.Writer
+	origins map[string]*geo.Reference
+}
+
+func (c *csvWriter) write(r history.Record) error {
+	var lat, lon string
+	if at, ok := closestPoint(r); ok && c.origins[r.Site] != nil {
+		la, lo := c.origins[r.Site].ToWGS84(at.X, at.Y)
+		lat, lon = strconv.FormatFloat(la, 'f', 6, 64), strconv.FormatFloat(lo, 'f', 6, 64)
+	}
+	return c.w.Write([]string{
+		r.ID, r.Site, r.Pilot.PilotID, r.Pilot.FirstName, r.Pilot.LastName, r.Pilot.Email, r.Pilot.PhoneNumber,
+		strconv.FormatFloat(r.ClosestDistance, 'f', -1, 64), lat, lon,
+		formatTime(r.Start), formatTime(r.End),
+		strconv.Itoa(len(r.Episodes)),
+		strconv.FormatFloat(timeInZone(r), 'f', -1, 64),
+		strconv.FormatBool(r.Anonymised),
+	})
+}
+
+func (c *csvWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonlWriter) write(r history.Record) error {
+	return j.encoder.Encode(r)
+}
+
+func (j *jsonlWriter) close() error {
+	return nil
+}
+
+// geojsonWriter writes a feature per record, a point at its closest
+// approach or no geometry when it has no episodes
+type geojsonWriter struct {
+	w       io.Writer
+	origins map[string]*geo.Reference
+	written bool
+}
+
+type feature struct {
+	Type       string         `json:"type"`
+	Geometry   *point         `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type point struct {
+	Type string `json:"type"`
+	// Coordinates are the longitude and latitude
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+func (g *geojsonWriter) write(r history.Record) error {
+	f := feature{
+		Type: "Feature",
+		Properties: map[string]any{
+			"id":              r.ID,
+			"site":            r.Site,
+			"pilot":           r.Pilot,
+			"closestDistance": r.ClosestDistance,
+			"start":           formatTime(r.Start),
+			"end":             formatTime(r.End),
+			"episodes":        len(r.Episodes),
+			"timeInZone":      timeInZone(r),
+			"anonymised":      r.Anonymised,
+		},
+	}
+	if at, ok := closestPoint(r); ok {
+		lat, lon := g.origins[r.Site].ToWGS84(at.X, at.Y)
+		f.Geometry = &point{Type: "Point", Coordinates: [2]float64{lon, lat}}
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if g.written {
+		data = append([]byte{','}, data...)
+	}
+	g.written = true
+	_, err = g.w.Write(data)
+	return err
+}
+
+func (g *geojsonWriter) close() error {
+	_, err := io.WriteString(g.w, "]}\n")
+	return err
+}
+
+// closestPoint returns where the drone came closest over the record's
+// episodes, false without episodes
+func closestPoint(r history.Record) (models.Point, bool) {
+	closest := -1
+	for i, e := range r.Episodes {
+		if closest < 0 || e.ClosestDistance < r.Episodes[closest].ClosestDistance {
+			closest = i
+		}
+	}
+	if closest < 0 {
+		return models.Point{}, false
+	}
+	return r.Episodes[closest].ClosestPoint, true
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// timeInZone is the seconds of the record's episodes
+func timeInZone(r history.Record) float64 {
+	total := 0.0
+	for _, e := range r.Episodes {
+		total += e.Duration
+	}
+	return total
+}
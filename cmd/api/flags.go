@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"strings"
+	"time"
+)
+
+// legacyFlags registers the flags used before the configuration file. The
+// returned function, called after parsing, gives the override of the flags
+// set on the command line, which take precedence over the file and the
+// environment, and their names to warn about.
+func legacyFlags(flags *flag.FlagSet) func() (config.Override, []string) {
+	port := flags.Int("port", 0, "Deprecated, use http.port")
+	sleep := flags.Int("sleep", 0, "Deprecated, use poll.interval (milliseconds)")
+	pollOffset := flags.Int("poll-offset", 0, "Deprecated, use poll.offset (milliseconds)")
+	maxBackoff := flags.Int("max-backoff", 0, "Deprecated, use poll.maxBackoff (seconds)")
+	persist := flags.Int("persist", 0, "Deprecated, use persistence.ttl (minutes)")
+	sitesPath := flags.String("sites", "", "Deprecated, use sites (JSON file)")
+	upstream := flags.String("upstream", "", "Deprecated, use upstream")
+	radius := flags.Float64("no-fly-zone-radius", 0, "Deprecated, use zones (meters)")
+	originX := flags.Float64("no-fly-zone-origin-x", 0, "Deprecated, use zones (millimeters)")
+	originY := flags.Float64("no-fly-zone-origin-y", 0, "Deprecated, use zones (millimeters)")
+	redisURL := flags.String("redis-url", "", "Deprecated, use persistence.redisUrl")
+
+	overrides := map[string]func(c *config.Config){
+		"port":                 func(c *config.Config) { c.HTTP.Port = *port },
+		"sleep":                func(c *config.Config) { c.Poll.Interval = time.Duration(*sleep) * time.Millisecond },
+		"poll-offset":          func(c *config.Config) { c.Poll.Offset = time.Duration(*pollOffset) * time.Millisecond },
+		"max-backoff":          func(c *config.Config) { c.Poll.MaxBackoff = time.Duration(*maxBackoff) * time.Second },
+		"persist":              func(c *config.Config) { c.Persistence.TTL = time.Duration(*persist) * time.Minute },
+		"upstream":             func(c *config.Config) { c.Upstream = *upstream },
+		"no-fly-zone-radius":   func(c *config.Config) { defaultZone(c).Radius = *radius },
+		"no-fly-zone-origin-x": func(c *config.Config) { defaultZone(c).OriginX = *originX },
+		"no-fly-zone-origin-y": func(c *config.Config) { defaultZone(c).OriginY = *originY },
+		"redis-url":            func(c *config.Config) { c.Persistence.RedisURL = *redisURL },
+		// Read on every load like the configuration file
+		"sites": nil,
+	}
+
+	return func() (config.Override, []string) {
+		var set []string
+		flags.Visit(func(f *flag.Flag) {
+			if _, ok := overrides[f.Name]; ok {
+				set = append(set, f.Name)
+			}
+		})
+		if len(set) == 0 {
+			return nil, nil
+		}
+		return func(c *config.Config) error {
+			for _, name := range set {
+				if name != "sites" {
+					overrides[name](c)
+					continue
+				}
+				sites, err := loadSites(*sitesPath)
+				if err != nil {
+					return err
+				}
+				c.Sites = sites
+			}
+			return nil
+		}, set
+	}
+}
+
+// warnDeprecated tells the user of a command which flags to move into the
+// configuration file
+func warnDeprecated(deprecated []string) {
+	if len(deprecated) != 0 {
+		fmt.Fprintln(os.Stderr, "deprecated flags override the configuration file, move them into it:", strings.Join(deprecated, ", "))
+	}
+}
+
+// defaultZone returns the only zone of the default site the flags could
+// describe, keeping the values not given of the first configured zone
+func defaultZone(c *config.Config) *models.Zone {
+	if len(c.Zones) == 0 {
+		c.Zones = config.Default().Zones
+	}
+	c.Zones = c.Zones[:1:1]
+	return &c.Zones[0]
+}
+
+// loadSites reads the sites of the former -sites JSON file
+func loadSites(path string) ([]config.Site, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sites []config.Site
+	if err := json.NewDecoder(f).Decode(&sites); err != nil {
+		return nil, fmt.Errorf("invalid sites file %s: %w", path, err)
+	}
+	return sites, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image/png"
+	"net/http"
+	"reaktor-birdnest/internal/heatmap"
+	"strings"
+	"time"
+)
+
+// defaultHeatmapWindow is the window of the heatmap without ?window=
+const defaultHeatmapWindow = time.Hour
+
+// heatmapResponse is the JSON grid with the corners of its PNG on the globe
+// for GIS tools, clockwise from the top left as [lon, lat], when the site has
+// an origin
+type heatmapResponse struct {
+	heatmap.Grid
+	Corners [][2]float64 `json:"corners,omitempty"`
+}
+
+// serveHeatmap returns the heatmap of the latest ?window= as a JSON grid, or
+// as a PNG of the ?layer= drones or incursions when asked for with
+// ?format=png or Accept: image/png
+func (app *application) serveHeatmap(w http.ResponseWriter, r *http.Request, s *site) {
+	if _, ok := app.role(w, r); !ok {
+		return
+	}
+
+	sc, _ := app.cfg.Load().Site(s.cfg.ID)
+	cfg := app.cfg.Load().Heatmap
+	window := defaultHeatmapWindow
+	if raw := r.URL.Query().Get("window"); len(raw) != 0 {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 || d > cfg.Retention {
+			writeError(w, http.StatusBadRequest, "window must be a duration up to heatmap.retention, "+cfg.Retention.String())
+			return
+		}
+		window = d
+	}
+	grid := s.heatmap.Grid(window, time.Now())
+
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 && strings.Contains(r.Header.Get("Accept"), "image/png") {
+		format = "png"
+	}
+	switch format {
+	case "", "json":
+		response := heatmapResponse{Grid: grid}
+		if sc.Origin != nil {
+			// The grid may reach past the sensor area when the cells do not
+			// divide it
+			side := float64(len(grid.Drones)) * grid.CellSize
+			for _, corner := range [][2]float64{{0, side}, {side, side}, {side, 0}, {0, 0}} {
+				lat, lon := sc.Origin.ToWGS84(corner[0], corner[1])
+				response.Corners = append(response.Corners, [2]float64{lon, lat})
+			}
+		}
+		writeJSON(w, http.StatusOK, response)
+	case "png":
+		counts := grid.Drones
+		switch r.URL.Query().Get("layer") {
+		case "", "drones":
+		case "incursions":
+			counts = grid.Incursions
+		default:
+			writeError(w, http.StatusBadRequest, "layer must be drones or incursions")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-cache")
+		png.Encode(w, heatmap.Image(counts))
+	default:
+		writeError(w, http.StatusBadRequest, "format must be json or png")
+	}
+}
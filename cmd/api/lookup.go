@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"reaktor-birdnest/internal/models"
+	"sync"
+	"time"
+)
+
+// lookupPool fetches the pilots of new violators with a bounded number of
+// workers. A lookup outlives the tick that requested it, so a slow upstream
+// delays only the violations waiting for their pilot.
+type lookupPool struct {
+	s        *site
+	timeout  time.Duration
+	jobs     chan *lookup
+	workers  sync.WaitGroup
+	mut      sync.Mutex
+	inflight map[string]*lookup
+}
+
+type lookup struct {
+	ctx    context.Context
+	logger *slog.Logger
+	serial string
+	// closest distance seen while the lookup was running
+	distance float64
+	done     chan struct{}
+}
+
+func newLookupPool(s *site, workers int, timeout time.Duration) *lookupPool {
+	p := &lookupPool{
+		s:        s,
+		timeout:  timeout,
+		jobs:     make(chan *lookup, workers*4),
+		inflight: make(map[string]*lookup),
+	}
+
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.workers.Done()
+			for l := range p.jobs {
+				p.run(l)
+			}
+		}()
+	}
+	return p
+}
+
+// request returns the stored violation of the drone if there is one, and
+// otherwise queues a lookup for it unless one is already running. The serial
+// is claimed under the lock before reading the store, and a lookup releases
+// it only once the violation is stored, so a tick never looks up a drone
+// whose lookup has just finished. The returned channel is closed when the
+// lookup finishes. It is nil when the queue is full, in which case the drone
+// is retried on the next tick.
+func (p *lookupPool) request(ctx context.Context, logger *slog.Logger, serial string, distance float64) (models.Violation, bool, <-chan struct{}) {
+	p.mut.Lock()
+	if l, ok := p.inflight[serial]; ok {
+		l.distance = min(l.distance, distance)
+		p.mut.Unlock()
+		return models.Violation{}, false, l.done
+	}
+	l := &lookup{
+		// Keep the trace but not the tick deadline
+		ctx:      context.WithoutCancel(ctx),
+		logger:   logger,
+		serial:   serial,
+		distance: distance,
+		done:     make(chan struct{}),
+	}
+	p.inflight[serial] = l
+	p.mut.Unlock()
+
+	if violation, found := p.s.violations.Get(serial); found {
+		p.release(l)
+		return violation, true, nil
+	}
+	select {
+	case p.jobs <- l:
+		return models.Violation{}, false, l.done
+	default:
+		logger.Warn("pilot lookup queue full", "serial", serial)
+		p.release(l)
+		return models.Violation{}, false, nil
+	}
+}
+
+// release lets the next tick request the drone again
+func (p *lookupPool) release(l *lookup) {
+	p.mut.Lock()
+	delete(p.inflight, l.serial)
+	p.mut.Unlock()
+	close(l.done)
+}
+
+func (p *lookupPool) run(l *lookup) {
+	defer p.release(l)
+
+	ctx, cancel := context.WithTimeout(l.ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	pilot, err := p.s.birdnest.GetDronePilot(ctx, l.serial)
+	if err != nil {
+		l.logger.Error("failed to get pilot", "serial", l.serial, "err", err, "latency", time.Since(start))
+		return
+	}
+
+	// A tick may have stored the drone after a previous lookup finished
+	existing, found := p.s.violations.Get(l.serial)
+	p.mut.Lock()
+	distance := l.distance
+	p.mut.Unlock()
+	if found {
+		distance = min(distance, existing.ClosestDistance)
+	}
+	l.logger.Info("new violation", "serial", l.serial, "pilot", pilot.PilotID, "distance", distance, "latency", time.Since(start))
+
+	p.s.violations.Upsert(l.serial, models.Violation{
+		Pilot:           pilot,
+		ClosestDistance: distance,
+	})
+}
+
+// stop waits for queued lookups to finish
+func (p *lookupPool) stop() {
+	close(p.jobs)
+	p.workers.Wait()
+}
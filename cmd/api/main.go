@@ -2,132 +2,327 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"github.com/go-redis/redis/v9"
 	"github.com/tmaxmax/go-sse"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	reaktorbirdnest "reaktor-birdnest"
-	"reaktor-birdnest/internal/interfaces"
+	"reaktor-birdnest/internal/auth"
+	"reaktor-birdnest/internal/breaker"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/devices"
+	"reaktor-birdnest/internal/heatmap"
+	"reaktor-birdnest/internal/history"
 	"reaktor-birdnest/internal/models"
 	"reaktor-birdnest/internal/models/birdnest"
+	"reaktor-birdnest/internal/notify"
+	"reaktor-birdnest/internal/persistence/codec"
 	"reaktor-birdnest/internal/persistence/datastore"
+	"reaktor-birdnest/internal/persistence/encryption"
 	"reaktor-birdnest/internal/persistence/myredis"
-	"sync"
+	"reaktor-birdnest/internal/rollup"
+	"reaktor-birdnest/internal/tracing"
+	"sync/atomic"
+	texttemplate "text/template"
 	"time"
 )
 
-type config struct {
-	serverPort       int
-	noFlyZoneOriginX float64
-	noFlyZoneOriginY float64
-	noFlyZoneRadius  float64
-	sleepDuration    time.Duration
-	persistDuration  time.Duration
-	redisUrl         string
-}
-
 type application struct {
-	sseHandler    *sse.Server
-	cfg           config
-	tmpl          *template.Template
-	homepage      []byte
-	homepageMutex sync.RWMutex
-	birdnest      interfaces.Birdnest
-	violations    interfaces.Violations
+	cfg      atomic.Pointer[config.Config]
+	tmpl     *template.Template
+	sites    []*site
+	logger   *slog.Logger
+	logLevel *slog.LevelVar
+	notifier notify.Sinks
+	history  *history.Archive
+	auth     *auth.Authenticator
+	// overrides of the deprecated flags
+	overrides config.Override
 }
 
 func main() {
-	var cfg config
-
-	flag.IntVar(&cfg.serverPort, "port", getEnvInt("PORT", 8080), "API server port")
-	var (
-		sleepDuration   int
-		persistDuration int
-	)
-	flag.IntVar(&sleepDuration, "sleep", 2000, "Timeout between drone position polls (milliseconds)")
-	flag.IntVar(&persistDuration, "persist", 10, "Time to persist violating pilots (minutes)")
-	flag.Float64Var(&cfg.noFlyZoneRadius, "no-fly-zone-radius", 100, "Radius of no-fly zone in meters")
-	flag.Float64Var(&cfg.noFlyZoneOriginX, "no-fly-zone-origin-x", 250000, "Origin X coordinate of no-fly zone in meters")
-	flag.Float64Var(&cfg.noFlyZoneOriginY, "no-fly-zone-origin-y", 250000, "Origin Y coordinate of no-fly zone in meters")
-	flag.StringVar(&cfg.redisUrl, "redis-url", os.Getenv("REDIS_URL"), "URL for connecting to Redis")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "erase":
+			os.Exit(eraseCommand(os.Args[2:]))
+		case "reencrypt":
+			os.Exit(reencryptCommand(os.Args[2:]))
+		}
+	}
 
+	var configPath string
+	flag.StringVar(&configPath, "config", os.Getenv("BIRDNEST_CONFIG"), "Path to the YAML configuration file")
+	legacy := legacyFlags(flag.CommandLine)
 	flag.Parse()
-	cfg.sleepDuration = time.Duration(sleepDuration) * time.Millisecond
-	cfg.persistDuration = time.Duration(persistDuration) * time.Minute
+	overrides, deprecated := legacy()
+
+	logLevel := new(slog.LevelVar)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: logLevel,
+		// Durations are more readable as "1.5ms" than as nanoseconds
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Value.Kind() == slog.KindDuration {
+				a.Value = slog.StringValue(a.Value.Duration().String())
+			}
+			return a
+		},
+	}))
+	slog.SetDefault(logger)
+
+	cfg, err := config.Load(configPath, overrides)
+	if err != nil {
+		logger.Error("invalid configuration", "err", err)
+		os.Exit(1)
+	}
+	logLevel.Set(cfg.Log.Level)
+	if len(deprecated) != 0 {
+		logger.Warn("deprecated flags override the configuration file, move them into it", "flags", deprecated)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error("failed to set up tracing", "err", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
 
 	tmpl, err := template.ParseFS(reaktorbirdnest.TemplateFS, "ui/html/*")
 	if err != nil {
 		panic("failed to read templates")
 	}
 
-	homeBuf := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(homeBuf, "home", nil)
+	app := &application{
+		tmpl:     tmpl,
+		logger:   logger,
+		logLevel: logLevel,
+		auth:     auth.New(cfg.Auth),
+		// Kept on reload
+		overrides: overrides,
+	}
+	app.cfg.Store(cfg)
+
+	app.history, err = history.Open(cfg.History.Path)
 	if err != nil {
-		panic("failed to render initial home template")
+		logger.Error("failed to open the history", "path", cfg.History.Path, "err", err)
+		os.Exit(1)
 	}
+	defer app.history.Close()
 
-	app := &application{
-		sseHandler: sse.NewServer(),
-		cfg:        cfg,
-		tmpl:       tmpl,
-		birdnest:   birdnest.Birdnest{},
-		homepage:   homeBuf.Bytes(),
+	var sinks notify.Sinks
+	if len(cfg.Notify.Webhooks) != 0 {
+		webhooks, err := notify.NewWebhooks(cfg.Notify, logger)
+		if err != nil {
+			logger.Error("failed to open the webhook outbox", "path", cfg.Notify.Outbox, "err", err)
+			os.Exit(1)
+		}
+		defer webhooks.Close()
+		sinks = append(sinks, webhooks)
+	}
+	if len(cfg.Notify.Email.Addr) != 0 {
+		emailTmpl, err := texttemplate.ParseFS(reaktorbirdnest.TemplateFS, "ui/email/*")
+		if err != nil {
+			panic("failed to read email templates")
+		}
+		email := notify.NewEmail(cfg.Notify.Email, emailTmpl, logger)
+		defer email.Close()
+		sinks = append(sinks, email)
+	}
+	if len(cfg.Notify.MQTT.Broker) != 0 {
+		publisher := notify.NewMQTT(cfg.Notify.MQTT, logger)
+		defer publisher.Close()
+		sinks = append(sinks, publisher)
+	}
+	app.notifier = sinks
+
+	var redisOpt *redis.Options
+	backend := "datastore"
+	if len(cfg.Persistence.RedisURL) != 0 {
+		// Already validated when loading the configuration
+		redisOpt, _ = redis.ParseURL(cfg.Persistence.RedisURL)
+		backend = "redis"
+	}
+	keyring, err := encryption.Load(cfg.Persistence.Encryption)
+	if err != nil {
+		logger.Error("failed to load the encryption keys", "err", err)
+		os.Exit(1)
 	}
+	logger.Info("using persistence", "backend", backend, "codec", cfg.Persistence.Codec, "encrypted", keyring != nil)
+
+	for _, sc := range cfg.Sites {
+		s := &site{
+			cfg:        sc,
+			sseHandler: sse.NewServer(sse.WithLogger(slog.NewLogLogger(logger.Handler(), slog.LevelWarn))),
+			backend:    backend,
+			heatmap:    heatmap.New(cfg.Heatmap),
+			devices:    devices.New(cfg.Devices.Retention),
+			homepages:  make(map[auth.Role][]byte, len(auth.Roles)),
+		}
+		s.birdnest = breaker.New(birdnest.New(sc.Upstream, cfg.Validation.Strict), cfg.Breaker.FailureThreshold, cfg.Breaker.Cooldown, func(state breaker.State, since time.Time) {
+			app.processStatus(s, state, since)
+		})
+
+		if redisOpt != nil {
+			s.violations, err = myredis.New[models.Violation](redisOpt, sc.Namespace, cfg.Persistence.TTL, codec.Codecs[cfg.Persistence.Codec], keyring)
+			if err != nil {
+				logger.Error("failed to connect to Redis", "site", sc.ID, "err", err)
+				os.Exit(1)
+			}
+		} else {
+			s.violations = datastore.New[models.Violation](cfg.Persistence.TTL)
+		}
+		s.tracker = notify.NewTracker(sc.ID, cfg.Notify.Thresholds)
 
-	if len(cfg.redisUrl) != 0 {
-		url, err := redis.ParseURL(cfg.redisUrl)
+		var store rollup.Store = rollup.Memory{}
+		switch cfg.Rollups.Backend {
+		case config.RollupsFile:
+			fileRollups, err := rollup.OpenFile(cfg.Rollups.Path, sc.ID)
+			if err != nil {
+				logger.Error("failed to open the rollups", "site", sc.ID, "path", cfg.Rollups.Path, "err", err)
+				os.Exit(1)
+			}
+			store = fileRollups
+		case config.RollupsRedis:
+			redisRollups := myredis.NewRollups(redisOpt, sc.Namespace)
+			defer redisRollups.Close()
+			store = redisRollups
+		}
+		rollups, err := rollup.New(store, rollupsKept(cfg.Rollups))
 		if err != nil {
-			log.Fatalf("invalid url %v, %s", err, cfg.redisUrl)
+			logger.Error("failed to load the rollups", "site", sc.ID, "backend", cfg.Rollups.Backend, "err", err)
+			os.Exit(1)
+		}
+		s.rollups = rollups
+
+		for _, role := range auth.Roles {
+			s.homepages[role], err = app.render("home", s, role)
+			if err != nil {
+				panic("failed to render initial home template")
+			}
 		}
-		fmt.Println("Using Redis")
-		app.violations = myredis.New[models.Violation](url, cfg.persistDuration)
-	} else {
-		fmt.Println("Using datastore")
-		app.violations = datastore.New[models.Violation](cfg.persistDuration)
+		app.sites = append(app.sites, s)
 	}
 
-	go app.monitor(make(chan bool), app.processViolations)
-	http.ListenAndServe(fmt.Sprintf(":%d", cfg.serverPort), app.routes())
+	for _, s := range app.sites {
+		s := s
+		go app.monitor(s, make(chan bool), func(ctx context.Context, o observation) {
+			app.dispatch(ctx, s, o)
+		})
+	}
+	go app.watchConfig(configPath)
+	go app.enforceRetention()
+	logger.Info("starting server", "port", cfg.HTTP.Port, "sites", len(app.sites))
+	err = http.ListenAndServe(fmt.Sprintf(":%d", cfg.HTTP.Port), app.routes())
+	logger.Error("server stopped", "err", err)
 }
 
 func (app *application) routes() *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/events", app.sseHandler.ServeHTTP)
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		app.homepageMutex.RLock()
-		defer app.homepageMutex.RUnlock()
-		w.Write(app.homepage)
+	mux.HandleFunc("GET /{$}", app.overview)
+	mux.HandleFunc("GET /sites/{id}", app.withSite(func(w http.ResponseWriter, r *http.Request, s *site) {
+		role, ok := app.role(w, r)
+		if !ok {
+			return
+		}
+		s.homepageMutex.RLock()
+		defer s.homepageMutex.RUnlock()
+		w.Write(s.homepages[role])
+	}))
+	// Paths of the single-site release
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		app.redirectToSite(w, r, app.sites[0], "/events")
 	})
+	mux.HandleFunc("GET /sites/{id}/events", app.withSite(func(w http.ResponseWriter, r *http.Request, s *site) {
+		if role, ok := app.role(w, r); ok {
+			app.serveEvents(w, r, s, string(role), 0)
+		}
+	}))
+	mux.HandleFunc("GET /sites/{id}/map", app.withSite(app.radar))
+	mux.HandleFunc("GET /sites/{id}/positions", app.withSite(app.servePositions))
+	mux.HandleFunc("GET /sites/{id}/heatmap", app.withSite(app.serveHeatmap))
+	mux.HandleFunc("GET /sites/{id}/stats", app.withSite(app.statsPage))
+	mux.HandleFunc("GET /login", app.login)
+	mux.HandleFunc("GET /api/violations", app.listViolations)
+	for format := range exportFormats {
+		mux.HandleFunc("GET /api/violations."+format, app.exportViolations(format))
+		mux.HandleFunc("GET /api/history."+format, app.exportHistory(format))
+	}
+	mux.HandleFunc("GET /api/snapshot/latest", app.latestSnapshot)
+	mux.HandleFunc("GET /api/stats/pilots", app.pilotStats)
+	mux.HandleFunc("GET /api/stats/timeseries", app.timeseries)
+	mux.HandleFunc("GET /api/stats/drones", app.droneStats)
+	mux.HandleFunc("GET /api/drones", app.listDrones)
+	mux.HandleFunc("GET /leaderboard", app.leaderboard)
+	mux.HandleFunc("GET /debug/vars", app.requireAdmin(expvar.Handler().ServeHTTP))
+	mux.HandleFunc("GET /admin/log-level", app.requireAdmin(app.getLogLevel))
+	mux.HandleFunc("PUT /admin/log-level", app.requireAdmin(app.setLogLevel))
+	mux.HandleFunc("POST /admin/erasure", app.requireAdmin(app.eraseHandler))
 
 	return mux
 }
 
-type templateData struct {
-	Violations []models.Violation
+func (app *application) withSite(handler func(http.ResponseWriter, *http.Request, *site)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := app.site(r.PathValue("id"))
+		if s == nil {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r, s)
+	}
+}
+
+// redirectToSite redirects to the path under the site, keeping the query
+func (app *application) redirectToSite(w http.ResponseWriter, r *http.Request, s *site, path string) {
+	u := url.URL{Path: "/sites/" + url.PathEscape(s.cfg.ID) + path, RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, u.String(), http.StatusFound)
 }
 
-func (app *application) processViolations(violations []models.Violation) {
-	td := &templateData{
-		Violations: violations,
+func (app *application) site(id string) *site {
+	for _, s := range app.sites {
+		if s.cfg.ID == id {
+			return s
+		}
 	}
+	return nil
+}
 
-	homeBuf := new(bytes.Buffer)
-	err := app.tmpl.ExecuteTemplate(homeBuf, "home", td)
-	if err == nil {
-		app.homepageMutex.Lock()
-		app.homepage = homeBuf.Bytes()
-		app.homepageMutex.Unlock()
+type siteSummary struct {
+	Site       config.Site
+	Violations int
+	Status     siteStatus
+}
+
+func (app *application) overview(w http.ResponseWriter, r *http.Request) {
+	// A single site is its own overview, as it was before sites
+	if len(app.sites) == 1 {
+		app.redirectToSite(w, r, app.sites[0], "")
+		return
+	}
+	cfg := app.cfg.Load()
+	summaries := make([]siteSummary, 0, len(app.sites))
+	for _, s := range app.sites {
+		sc, _ := cfg.Site(s.cfg.ID)
+		s.homepageMutex.RLock()
+		status := s.status
+		s.homepageMutex.RUnlock()
+		summaries = append(summaries, siteSummary{
+			Site:       sc,
+			Violations: len(s.violations.AsSlice()),
+			Status:     status,
+		})
 	}
 
-	pilotBuf := new(bytes.Buffer)
-	err = app.tmpl.ExecuteTemplate(pilotBuf, "pilot", td)
-	if err == nil {
-		e := &sse.Message{}
-		e.AppendData(pilotBuf.Bytes())
-		app.sseHandler.Publish(e)
+	buf := new(bytes.Buffer)
+	if err := app.tmpl.ExecuteTemplate(buf, "overview", summaries); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
+	w.Write(buf.Bytes())
 }
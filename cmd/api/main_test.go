@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSingleSiteRedirects(t *testing.T) {
+	app, s := newApp()
+	app.sites = []*site{s}
+
+	for url, location := range map[string]string{
+		"/":               "/sites/test",
+		"/events?role=on": "/sites/test/events?role=on",
+	} {
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusFound || w.Header().Get("Location") != location {
+			t.Errorf("Expected %s to redirect to %s, but was %d to %q.", url, location, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
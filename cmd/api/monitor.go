@@ -1,72 +1,210 @@
 package main
 
 import (
-	"fmt"
-	"math"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"reaktor-birdnest/internal/config"
 	"reaktor-birdnest/internal/models"
-	"sync"
 	"time"
 )
 
-func (app *application) monitor(done <-chan bool, dispatchViolations func([]models.Violation)) {
-	ticker := time.NewTicker(app.cfg.sleepDuration)
+var tracer = otel.Tracer("reaktor-birdnest/cmd/api")
+
+// polled is the outcome of a tick's report request, which decides when the
+// next tick is due
+type polled struct {
+	logger *slog.Logger
+	report models.Report
+	err    error
+}
+
+// observation is what a tick saw. Drones are only set when the report
+// succeeded and violations only when they changed.
+type observation struct {
+	time       time.Time
+	reported   bool
+	drones     []models.Drone
+	changed    bool
+	violations []models.Violation
+}
+
+// maxQueuedTicks bounds the ticks queued behind a running one so that a
+// sensor slower than the poll interval does not queue them forever
+const maxQueuedTicks = 10
+
+func (app *application) monitor(s *site, done <-chan bool, dispatch func(context.Context, observation)) {
+	cfg := app.cfg.Load()
+	sched := newSchedule(cfg.Poll.Interval, cfg.Poll.Offset, cfg.Poll.MaxBackoff)
+	timer := time.NewTimer(cfg.Poll.Interval)
+	pool := newLookupPool(s, cfg.Poll.Workers, cfg.Poll.LookupTimeout)
+	siteLogger := app.logger.With("site", s.cfg.ID, "backend", s.backend)
+
+	reported := make(chan polled)
+	finished := make(chan struct{})
+	running, pending := false, 0
+	start := func() {
+		// Settings may have been reloaded since the previous tick
+		cfg := app.cfg.Load()
+		sched.configure(cfg.Poll)
+		running = true
+		go func() {
+			app.tick(cfg, s, pool, siteLogger, reported, dispatch)
+			finished <- struct{}{}
+		}()
+	}
+
 	for {
 		select {
 		case <-done:
-			ticker.Stop()
-			app.violations.Destroy()
+			timer.Stop()
+			for running {
+				select {
+				case <-reported:
+				case <-finished:
+					running = false
+				}
+			}
+			pool.stop()
+			s.violations.Destroy()
 			return
-		case <-ticker.C:
-			report, err := app.birdnest.GetReport()
-			if err != nil {
-				fmt.Println(err)
+		case <-timer.C:
+			if !running {
+				start()
+				continue
 			}
 
-			wg := sync.WaitGroup{}
-			for _, drone := range report.Capture.Drone {
-				// Capture variable for goroutine
-				drone := drone
-
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					distance := math.Hypot(app.cfg.noFlyZoneOriginX-drone.PositionX, app.cfg.noFlyZoneOriginY-drone.PositionY)
-					// Convert millimeters to meters
-					distance /= 1000
-					if distance > app.cfg.noFlyZoneRadius {
-						return
-					}
-
-					// Check if violation entry exists already
-					violation, found := app.violations.Get(drone.SerialNumber)
-					if found {
-						if distance < violation.ClosestDistance {
-							violation.ClosestDistance = distance
-						}
-					} else {
-						pilot, err := app.birdnest.GetDronePilot(drone.SerialNumber)
-						if err != nil {
-							fmt.Println(err)
-							return
-						}
-
-						violation = models.Violation{
-							Pilot:           pilot,
-							ClosestDistance: distance,
-						}
-					}
-
-					app.violations.Upsert(drone.SerialNumber, violation)
-				}()
+			switch app.cfg.Load().Poll.Overrun {
+			case config.OverrunSkip:
+				siteLogger.Warn("previous tick still running, skipping tick")
+			case config.OverrunCoalesce:
+				pending = 1
+			case config.OverrunQueue:
+				if pending == maxQueuedTicks {
+					siteLogger.Warn("too many ticks queued, skipping tick", "queued", pending)
+					break
+				}
+				pending++
 			}
-			wg.Wait()
-
-			// Try to send new event only when something has changed
-			if app.violations.HasChanges() {
-				dispatchViolations(app.violations.AsSlice())
+			resetTimer(timer, sched.interval)
+		case p := <-reported:
+			// Align the next poll with the sensor's own update interval
+			resetTimer(timer, sched.next(p.logger, p.report, p.err))
+		case <-finished:
+			running = false
+			if pending > 0 {
+				pending--
+				start()
 			}
+		}
+	}
+}
+
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
 		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+func (app *application) tick(cfg *config.Config, s *site, pool *lookupPool, siteLogger *slog.Logger, reported chan<- polled, dispatch func(context.Context, observation)) {
+	sc, _ := cfg.Site(s.cfg.ID)
+	tick := newTickID()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Poll.TickTimeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "monitor.tick", trace.WithAttributes(
+		attribute.String("site", s.cfg.ID),
+		attribute.String("tick", tick),
+	))
+	defer span.End()
+
+	logger := siteLogger.With("tick", tick)
+	if span.SpanContext().IsValid() {
+		logger = logger.With("trace", span.SpanContext().TraceID().String())
+	}
+
+	start := time.Now()
+	report, err := s.birdnest.GetReport(ctx, logger)
+	reported <- polled{logger: logger, report: report, err: err}
+	o := observation{time: start}
+	var lookups []<-chan struct{}
+	if err != nil {
+		// Expired violations are still dispatched below
+		logger.Error("failed to get report", "err", err, "latency", time.Since(start))
+	} else {
+		logger.Debug("got report", "drones", len(report.Capture.Drone), "latency", time.Since(start))
+		o.reported, o.drones = true, locateDrones(sc.Origin, report.Capture.Drone)
+		lookups = app.checkDrones(ctx, s, sc, pool, logger, report.Capture.Drone)
+	}
+
+wait:
+	for _, l := range lookups {
+		select {
+		case <-l:
+		case <-ctx.Done():
+			// Violations of the unfinished lookups are dispatched by a later tick
+			logger.Warn("tick deadline exceeded, pilot lookups continue in the background", "timeout", cfg.Poll.TickTimeout)
+			break wait
+		}
+	}
+
+	// Violations are only sent when something has changed
+	_, changesSpan := tracer.Start(ctx, "violations.HasChanges")
+	o.changed = s.violations.HasChanges()
+	changesSpan.End()
+	if o.changed {
+		_, sliceSpan := tracer.Start(ctx, "violations.AsSlice")
+		o.violations = s.violations.AsSlice()
+		sliceSpan.End()
+	}
+	dispatch(ctx, o)
+}
+
+// checkDrones updates the violations of known violators and requests pilot
+// lookups for new ones, returning the lookups to wait for
+func (app *application) checkDrones(ctx context.Context, s *site, sc config.Site, pool *lookupPool, logger *slog.Logger, drones []models.Drone) []<-chan struct{} {
+	var lookups []<-chan struct{}
+	for _, drone := range drones {
+		distance, inside := models.Inside(sc.Zones, drone)
+		if !inside {
+			continue
+		}
+
+		// Check if violation entry exists already
+		_, getSpan := tracer.Start(ctx, "violations.Get", trace.WithAttributes(attribute.String("drone.serial", drone.SerialNumber)))
+		violation, found, l := pool.request(ctx, logger, drone.SerialNumber, distance)
+		getSpan.End()
+		if !found {
+			if l != nil {
+				lookups = append(lookups, l)
+			}
 			continue
 		}
+
+		if distance < violation.ClosestDistance {
+			violation.ClosestDistance = distance
+		}
+
+		start := time.Now()
+		_, upsertSpan := tracer.Start(ctx, "violations.Upsert", trace.WithAttributes(attribute.String("drone.serial", drone.SerialNumber)))
+		s.violations.Upsert(drone.SerialNumber, violation)
+		upsertSpan.End()
+		logger.Debug("upserted violation", "serial", drone.SerialNumber, "pilot", violation.Pilot.PilotID, "latency", time.Since(start))
 	}
+	return lookups
+}
+
+// newTickID identifies the log lines of a single poll
+func newTickID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -1,32 +1,48 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reaktor-birdnest/internal/auth"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/devices"
+	"reaktor-birdnest/internal/heatmap"
+	"reaktor-birdnest/internal/history"
+	"reaktor-birdnest/internal/interfaces"
 	"reaktor-birdnest/internal/models"
 	"reaktor-birdnest/internal/persistence/datastore"
+	"reaktor-birdnest/internal/rollup"
 	"strings"
 	"testing"
 	"time"
 )
 
 func TestAddingViolations(t *testing.T) {
-	app := newApp()
-	app.violations = datastore.New[models.Violation](app.cfg.persistDuration)
+	app, s := newApp()
+	s.violations = datastore.New[models.Violation](app.cfg.Load().Persistence.TTL)
 
 	expectedDistance := 50.0
-	violations := runMonitor(&app, &BirdnestMock{
+	violations := runMonitor(app, s, &BirdnestMock{
 		drones: [][]DronePartial{
 			{
 				{
 					SerialNumber: "123",
-					PositionY:    app.cfg.noFlyZoneOriginY,
-					PositionX:    app.cfg.noFlyZoneOriginX + expectedDistance*1000,
+					PositionY:    zone.OriginY,
+					PositionX:    zone.OriginX + expectedDistance*1000,
 				},
 				{
 					SerialNumber: "456",
-					PositionY:    app.cfg.noFlyZoneOriginY,
-					PositionX:    app.cfg.noFlyZoneOriginX + 10000000000000,
+					PositionY:    zone.OriginY,
+					PositionX:    zone.OriginX + 10000000000000,
 				},
 			},
 		},
@@ -56,19 +72,18 @@ func TestAddingViolations(t *testing.T) {
 }
 
 func TestRemoval(t *testing.T) {
-	app := newApp()
+	app, s := newApp()
 	// Set duration to zero violations are removed in the next tick
-	app.cfg.persistDuration = 0
-	app.violations = datastore.New[models.Violation](app.cfg.persistDuration)
+	s.violations = datastore.New[models.Violation](0)
 
 	expectedDistance := 50.0
-	violations := runMonitor(&app, &BirdnestMock{
+	violations := runMonitor(app, s, &BirdnestMock{
 		drones: [][]DronePartial{
 			{
 				{
 					SerialNumber: "123",
-					PositionY:    app.cfg.noFlyZoneOriginY,
-					PositionX:    app.cfg.noFlyZoneOriginX + expectedDistance*1000,
+					PositionY:    zone.OriginY,
+					PositionX:    zone.OriginX + expectedDistance*1000,
 				},
 			},
 		},
@@ -97,33 +112,33 @@ func TestRemoval(t *testing.T) {
 }
 
 func TestUpdateExistingPilot(t *testing.T) {
-	app := newApp()
-	app.violations = datastore.New[models.Violation](app.cfg.persistDuration)
+	app, s := newApp()
+	s.violations = datastore.New[models.Violation](app.cfg.Load().Persistence.TTL)
 
 	firstDistance := 50.0
 	secondDistance := 40.0
 
-	violations := runMonitor(&app, &BirdnestMock{
+	violations := runMonitor(app, s, &BirdnestMock{
 		drones: [][]DronePartial{
 			{
 				{
 					SerialNumber: "123",
-					PositionY:    app.cfg.noFlyZoneOriginY,
-					PositionX:    app.cfg.noFlyZoneOriginX + firstDistance*1000,
+					PositionY:    zone.OriginY,
+					PositionX:    zone.OriginX + firstDistance*1000,
 				},
 			},
 			{
 				{
 					SerialNumber: "456",
-					PositionY:    app.cfg.noFlyZoneOriginY,
-					PositionX:    app.cfg.noFlyZoneOriginX + firstDistance*1000,
+					PositionY:    zone.OriginY,
+					PositionX:    zone.OriginX + firstDistance*1000,
 				},
 			},
 			{
 				{
 					SerialNumber: "123",
-					PositionY:    app.cfg.noFlyZoneOriginY,
-					PositionX:    app.cfg.noFlyZoneOriginX + secondDistance*1000,
+					PositionY:    zone.OriginY,
+					PositionX:    zone.OriginX + secondDistance*1000,
 				},
 			},
 		},
@@ -162,14 +177,123 @@ func TestUpdateExistingPilot(t *testing.T) {
 	}
 }
 
-func runMonitor(app *application, birdnest *BirdnestMock) [][]models.Violation {
+func TestSlowPilotLookupDoesNotBlockTicks(t *testing.T) {
+	app, s := newApp()
+	cfg := app.cfg.Load()
+	cfg.Poll.TickTimeout = time.Millisecond
+	s.violations = datastore.New[models.Violation](cfg.Persistence.TTL)
+
+	// The violator is seen once, then the sensor keeps reporting an empty sky
+	drones := [][]DronePartial{{
+		{
+			SerialNumber: "123",
+			PositionY:    zone.OriginY,
+			PositionX:    zone.OriginX,
+		},
+	}}
+	for i := 0; i < 50; i++ {
+		drones = append(drones, []DronePartial{})
+	}
+	mock := &BirdnestMock{
+		drones:     drones,
+		pilots:     map[string]models.Pilot{"123": testingPilot("Bob")},
+		pilotDelay: 20 * time.Millisecond,
+	}
+	violations := runMonitor(app, s, mock)
+
+	if mock.current != len(drones) {
+		t.Errorf("Expected all %d reports to be polled, but was %d.", len(drones), mock.current)
+	}
+	if len(violations) != 1 {
+		t.Fatalf("Expected violations to be of length 1, but was %d.", len(violations))
+	}
+	if len(violations[0]) != 1 || violations[0][0].Pilot.FirstName != "Bob" {
+		t.Errorf("Expected the slow lookup to be dispatched by a later tick, but was %v.", violations[0])
+	}
+}
+
+func TestRequestKnownViolator(t *testing.T) {
+	app, s := newApp()
+	s.violations = datastore.New[models.Violation](time.Minute)
+	defer s.violations.Destroy()
+	s.birdnest = &BirdnestMock{pilots: map[string]models.Pilot{"123": testingPilot("Bob")}}
+	pool := newLookupPool(s, 1, time.Second)
+	defer pool.stop()
+
+	_, found, l := pool.request(context.Background(), app.logger, "123", 40)
+	if found || l == nil {
+		t.Fatalf("Expected a lookup for a new violator, but was %v, %v.", found, l)
+	}
+	<-l
+
+	// A tick that saw no violation before the lookup finished asks again
+	violation, found, l := pool.request(context.Background(), app.logger, "123", 30)
+	if !found || l != nil {
+		t.Fatalf("Expected the stored violation instead of a new lookup, but was %v, %v.", found, l)
+	}
+	if violation.Pilot.FirstName != "Bob" || violation.ClosestDistance != 40 {
+		t.Errorf("Expected the violation of Bob at 40, but was %v.", violation)
+	}
+}
+
+// slowStore holds reads of a serial like a slow Redis round trip
+type slowStore struct {
+	interfaces.Violations
+	serial  string
+	release chan struct{}
+}
+
+func (s slowStore) Get(id string) (models.Violation, bool) {
+	if id == s.serial {
+		<-s.release
+	}
+	return s.Violations.Get(id)
+}
+
+func TestRequestDoesNotReadUnderLock(t *testing.T) {
+	app, s := newApp()
+	store := datastore.New[models.Violation](time.Minute)
+	defer store.Destroy()
+	slow := slowStore{Violations: store, serial: "SN-1", release: make(chan struct{})}
+	s.violations = slow
+	s.birdnest = &BirdnestMock{pilots: map[string]models.Pilot{"SN-1": testingPilot("Alice"), "SN-2": testingPilot("Bob")}}
+	pool := newLookupPool(s, 1, time.Second)
+	defer pool.stop()
+
+	first := make(chan struct{})
+	go func() {
+		_, _, l := pool.request(context.Background(), app.logger, "SN-1", 40)
+		<-l
+		close(first)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	requested := make(chan struct{})
+	go func() {
+		_, _, l := pool.request(context.Background(), app.logger, "SN-2", 40)
+		<-l
+		close(requested)
+	}()
+	select {
+	case <-requested:
+	case <-time.After(time.Second):
+		t.Errorf("Expected a lookup of another drone to finish while the store is slow.")
+	}
+	close(slow.release)
+	<-first
+}
+
+func runMonitor(app *application, s *site, birdnest *BirdnestMock) [][]models.Violation {
 	done := make(chan bool, 1)
 	violations := make([][]models.Violation, 0)
 	birdnest.end = done
-	app.birdnest = birdnest
+	s.birdnest = birdnest
 
-	app.monitor(done, func(v []models.Violation) {
-		violations = append(violations, v)
+	app.monitor(s, done, func(ctx context.Context, o observation) {
+		if !o.changed {
+			return
+		}
+		violations = append(violations, o.violations)
 		// Give time for expiring
 		time.Sleep(2 * time.Millisecond)
 	})
@@ -187,16 +311,30 @@ func testingPilot(firstName string) models.Pilot {
 	}
 }
 
-func newApp() application {
-	return application{
-		cfg: config{
-			noFlyZoneOriginX: 250000,
-			noFlyZoneOriginY: 250000,
-			noFlyZoneRadius:  100,
-			sleepDuration:    time.Millisecond,
-			persistDuration:  10 * time.Minute,
-		},
+var zone = models.Zone{
+	OriginX: 250000,
+	OriginY: 250000,
+	Radius:  100,
+}
+
+func newApp() (*application, *site) {
+	sc := config.Site{
+		ID:    "test",
+		Zones: []models.Zone{zone},
 	}
+	archive, _ := history.Open("")
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		auth:    auth.New(config.Auth{}),
+		history: archive,
+	}
+	cfg := config.Default()
+	cfg.Poll.Interval = time.Millisecond
+	cfg.Poll.MaxBackoff = time.Millisecond
+	cfg.Sites = []config.Site{sc}
+	app.cfg.Store(cfg)
+	rollups, _ := rollup.New(rollup.Memory{}, rollupsKept(cfg.Rollups))
+	return app, &site{cfg: sc, heatmap: heatmap.New(cfg.Heatmap), devices: devices.New(cfg.Devices.Retention), rollups: rollups}
 }
 
 type DronePartial struct {
@@ -206,15 +344,20 @@ type DronePartial struct {
 }
 
 type BirdnestMock struct {
-	end     chan<- bool
-	drones  [][]DronePartial
-	pilots  map[string]models.Pilot
-	current int
+	end        chan<- bool
+	drones     [][]DronePartial
+	pilots     map[string]models.Pilot
+	pilotDelay time.Duration
+	current    int
 }
 
-func (b *BirdnestMock) GetReport() (models.Report, error) {
+func (b *BirdnestMock) GetReport(ctx context.Context, logger *slog.Logger) (models.Report, error) {
 	if b.current == len(b.drones) {
-		b.end <- true
+		// The monitor may poll again before noticing it is done
+		select {
+		case b.end <- true:
+		default:
+		}
 		return models.Report{}, errors.New("end")
 	}
 
@@ -243,20 +386,13 @@ func (b *BirdnestMock) GetReport() (models.Report, error) {
 			Local: "",
 		},
 		Text: "",
-		DeviceInformation: struct {
-			Text             string `xml:",chardata"`
-			DeviceId         string `xml:"deviceId,attr"`
-			ListenRange      string `xml:"listenRange"`
-			DeviceStarted    string `xml:"deviceStarted"`
-			UptimeSeconds    string `xml:"uptimeSeconds"`
-			UpdateIntervalMs string `xml:"updateIntervalMs"`
-		}{
+		DeviceInformation: models.DeviceInformation{
 			Text:             "",
 			DeviceId:         "",
 			ListenRange:      "",
 			DeviceStarted:    "",
-			UptimeSeconds:    "",
-			UpdateIntervalMs: "",
+			UptimeSeconds:    0,
+			UpdateIntervalMs: 0,
 		},
 		Capture: struct {
 			Text              string         `xml:",chardata"`
@@ -270,7 +406,8 @@ func (b *BirdnestMock) GetReport() (models.Report, error) {
 	}, nil
 }
 
-func (b *BirdnestMock) GetDronePilot(droneSerialNumber string) (models.Pilot, error) {
+func (b *BirdnestMock) GetDronePilot(ctx context.Context, droneSerialNumber string) (models.Pilot, error) {
+	time.Sleep(b.pilotDelay)
 	if pilot, ok := b.pilots[droneSerialNumber]; ok {
 		return pilot, nil
 	}
@@ -281,3 +418,68 @@ func (b *BirdnestMock) GetDronePilot(droneSerialNumber string) (models.Pilot, er
 func almostEquals(a, b, tolerance float64) bool {
 	return (a-b) < tolerance && (b-a) < tolerance
 }
+
+func TestClosestZone(t *testing.T) {
+	zones := []models.Zone{
+		zone,
+		{OriginX: zone.OriginX + 150000, OriginY: zone.OriginY, Radius: 100},
+	}
+
+	distance, inside := models.Inside(zones, models.Drone{PositionX: zone.OriginX + 90000, PositionY: zone.OriginY})
+	if !inside {
+		t.Fatalf("Expected drone to be inside a zone.")
+	}
+	if !almostEquals(distance, 60, 0.001) {
+		t.Errorf("Expected closest distance to be 60, but was %f.", distance)
+	}
+
+	if _, inside := models.Inside(zones, models.Drone{PositionX: 0, PositionY: 0}); inside {
+		t.Errorf("Expected drone at the corner to be outside every zone.")
+	}
+}
+
+func TestLegacyFlags(t *testing.T) {
+	sitesPath := filepath.Join(t.TempDir(), "sites.json")
+	os.WriteFile(sitesPath, []byte(`[{"id": "north", "upstream": "http://localhost:9999", "zones": [{"originX": 1000, "originY": 2000, "radius": 50}]}]`), 0o600)
+
+	flags := flag.NewFlagSet("api", flag.ContinueOnError)
+	legacy := legacyFlags(flags)
+	flags.Parse([]string{"-port", "9090", "-persist", "5", "-no-fly-zone-radius", "50"})
+	override, deprecated := legacy()
+	cfg, err := config.Load("", override)
+	if err != nil {
+		t.Fatalf("Expected config to load, but got %v.", err)
+	}
+	if cfg.HTTP.Port != 9090 || cfg.Persistence.TTL != 5*time.Minute || len(deprecated) != 3 {
+		t.Errorf("Expected the flags to override the defaults, but was %d, %v and %v.", cfg.HTTP.Port, cfg.Persistence.TTL, deprecated)
+	}
+	if z := cfg.Sites[0].Zones; len(z) != 1 || z[0] != (models.Zone{OriginX: 250000, OriginY: 250000, Radius: 50}) {
+		t.Errorf("Expected the default zone with a radius of 50, but was %v.", z)
+	}
+
+	flags = flag.NewFlagSet("api", flag.ContinueOnError)
+	legacy = legacyFlags(flags)
+	flags.Parse([]string{"-sites", sitesPath})
+	override, _ = legacy()
+	if cfg, err := config.Load("", override); err != nil || len(cfg.Sites) != 1 || cfg.Sites[0].ID != "north" || cfg.Sites[0].Zones[0].Radius != 50 {
+		t.Errorf("Expected the sites of the file, but was %v with %v.", cfg, err)
+	}
+}
+
+func TestEraseCommandLegacyFlags(t *testing.T) {
+	erased := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		erased <- r.URL.Path + " " + r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+	t.Setenv("BIRDNEST_HTTP_ADMINTOKEN", "secret")
+
+	// The server is found at the port of the deprecated flag
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	if code := eraseCommand([]string{"-port", port, "-pilot-id", "P-1"}); code != 0 {
+		t.Fatalf("Expected the erasure to succeed, but exited with %d.", code)
+	}
+	if request := <-erased; request != "/admin/erasure Bearer secret" {
+		t.Errorf("Expected an erasure request to the server, but was %q.", request)
+	}
+}
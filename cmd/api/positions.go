@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"reaktor-birdnest/internal/auth"
+	"reaktor-birdnest/internal/config"
+)
+
+// positionsPayload is the compact update of the map sent every tick. Each
+// drone is [serial, x, y, violator] with the position in meters and
+// violator 1 when the drone's pilot has a current violation, followed by
+// the ETA in seconds when an incursion is predicted. When the site has an
+// origin, the ETA, null if none, is followed by the latitude and longitude.
+type positionsPayload struct {
+	// Time of the tick in Unix milliseconds
+	Time   int64   `json:"t"`
+	Drones [][]any `json:"d"`
+}
+
+type mapData struct {
+	Site config.Site
+	// Positions are the latest published, drawn until the first update
+	Positions positionsPayload
+	Login     bool
+}
+
+// processPositions sends the drones of a snapshot to the map of every role,
+// flagging those whose serial is among the violators
+func (app *application) processPositions(ctx context.Context, s *site, snap *snapshot, violators map[string]bool) {
+	payload := positionsPayload{Time: snap.Time.UnixMilli(), Drones: make([][]any, 0, len(snap.Drones))}
+	for _, drone := range snap.Drones {
+		violator := 0
+		if violators[drone.SerialNumber] {
+			violator = 1
+		}
+		d := []any{
+			drone.SerialNumber,
+			millimetersToMeters(drone.PositionX),
+			millimetersToMeters(drone.PositionY),
+			violator,
+		}
+		var eta any
+		if drone.ETA != nil {
+			eta = math.Round(*drone.ETA*10) / 10
+		}
+		if drone.Lat != nil {
+			d = append(d, eta, roundDegrees(*drone.Lat), roundDegrees(*drone.Lon))
+		} else if eta != nil {
+			d = append(d, eta)
+		}
+		payload.Drones = append(payload.Drones, d)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	s.homepageMutex.Lock()
+	s.positions = payload
+	s.homepageMutex.Unlock()
+
+	// Positions carry no personal data, every role gets the same event
+	events := make(map[auth.Role][]byte, len(auth.Roles))
+	for _, role := range auth.Roles {
+		events[role] = data
+	}
+	app.publish(ctx, s, "positions", events)
+}
+
+// millimetersToMeters rounds to decimeters, which is plenty for the map
+func millimetersToMeters(mm float64) float64 {
+	return math.Round(mm/100) / 10
+}
+
+// roundDegrees rounds to about a decimeter like millimetersToMeters
+func roundDegrees(degrees float64) float64 {
+	return math.Round(degrees*1e6) / 1e6
+}
+
+// radar serves the live map of the site's sensor area
+func (app *application) radar(w http.ResponseWriter, r *http.Request, s *site) {
+	role, ok := app.role(w, r)
+	if !ok {
+		return
+	}
+
+	// Zones may have been reloaded
+	sc, _ := app.cfg.Load().Site(s.cfg.ID)
+	s.homepageMutex.RLock()
+	data := mapData{
+		Site:      sc,
+		Positions: s.positions,
+		Login:     role == auth.Public && app.auth.BasicAuth(),
+	}
+	s.homepageMutex.RUnlock()
+
+	buf := new(bytes.Buffer)
+	if err := app.tmpl.ExecuteTemplate(buf, "map", data); err != nil {
+		app.logger.Error("failed to render map", "site", s.cfg.ID, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// incursionWarning is the event warning the pages of a predicted incursion
+type incursionWarning struct {
+	Serial string  `json:"serial"`
+	ETA    float64 `json:"eta"`
+}
+
+// processIncursions warns the pages of every role of newly predicted
+// incursions
+func (app *application) processIncursions(ctx context.Context, s *site, predicted []dronePosition) {
+	for _, p := range predicted {
+		data, err := json.Marshal(incursionWarning{Serial: p.SerialNumber, ETA: math.Round(*p.ETA*10) / 10})
+		if err != nil {
+			continue
+		}
+		events := make(map[auth.Role][]byte, len(auth.Roles))
+		for _, role := range auth.Roles {
+			events[role] = data
+		}
+		app.publish(ctx, s, "incursion", events)
+	}
+}
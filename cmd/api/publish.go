@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/tmaxmax/go-sse"
+	"reaktor-birdnest/internal/auth"
+	"reaktor-birdnest/internal/breaker"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"reaktor-birdnest/internal/notify"
+	"time"
+)
+
+type templateData struct {
+	Site       config.Site
+	Violations []models.Violation
+	Status     siteStatus
+	Role       auth.Role
+	// Login links the public to the login page when users are configured
+	Login bool
+}
+
+// render executes the named template with the site's current state as seen
+// by the role. The caller must hold s.homepageMutex.
+func (app *application) render(name string, s *site, role auth.Role) ([]byte, error) {
+	td := &templateData{
+		Site:       s.cfg,
+		Violations: visibleTo(role, s.shown),
+		Status:     s.status,
+		Role:       role,
+		Login:      role == auth.Public && app.auth.BasicAuth(),
+	}
+
+	buf := new(bytes.Buffer)
+	err := app.tmpl.ExecuteTemplate(buf, name, td)
+	return buf.Bytes(), err
+}
+
+// visibleTo masks the pilots' contact details for everybody but staff
+func visibleTo(role auth.Role, violations []models.Violation) []models.Violation {
+	if role == auth.Staff {
+		return violations
+	}
+	masked := make([]models.Violation, len(violations))
+	for i, v := range violations {
+		masked[i] = v.Masked()
+	}
+	return masked
+}
+
+// renderHome renders the homepage and then the named partial to send to the
+// clients already on the page, both for every role
+func (app *application) renderHome(ctx context.Context, s *site, partial string) (map[auth.Role][]byte, error) {
+	s.homepageMutex.Lock()
+	defer s.homepageMutex.Unlock()
+
+	_, span := tracer.Start(ctx, "render.home")
+	for _, role := range auth.Roles {
+		if home, err := app.render("home", s, role); err == nil {
+			s.homepages[role] = home
+		}
+	}
+	span.End()
+
+	_, span = tracer.Start(ctx, "render."+partial)
+	defer span.End()
+	partials := make(map[auth.Role][]byte, len(auth.Roles))
+	for _, role := range auth.Roles {
+		p, err := app.render(partial, s, role)
+		if err != nil {
+			return nil, err
+		}
+		partials[role] = p
+	}
+	return partials, nil
+}
+
+// publish sends each role's version of an event to the clients of that role
+func (app *application) publish(ctx context.Context, s *site, name string, data map[auth.Role][]byte) {
+	_, span := tracer.Start(ctx, "sse.Publish")
+	defer span.End()
+	for role, d := range data {
+		e := &sse.Message{Topic: string(role)}
+		if len(name) != 0 {
+			e.SetName(name)
+		}
+		e.AppendData(d)
+		s.sseHandler.Publish(e)
+	}
+}
+
+func (app *application) processViolations(ctx context.Context, s *site, violations []models.Violation) {
+	s.homepageMutex.Lock()
+	s.shown = violations
+	s.homepageMutex.Unlock()
+
+	pilot, err := app.renderHome(ctx, s, "pilot")
+	if err == nil {
+		app.publish(ctx, s, "", pilot)
+	}
+}
+
+// dispatch hands what a tick observed to the page's event stream and the
+// notifiers
+func (app *application) dispatch(ctx context.Context, s *site, o observation) {
+	if o.changed {
+		app.processViolations(ctx, s, o.violations)
+	}
+	var predicted []dronePosition
+	if o.reported {
+		sc, _ := app.cfg.Load().Site(s.cfg.ID)
+		s.heatmap.Add(sc.Zones, o.drones, o.time)
+		for _, d := range s.devices.Observe(o.drones, o.time) {
+			for _, change := range d.Changes {
+				if change.Time.Equal(o.time) {
+					app.logger.Warn("drone address changed", "site", s.cfg.ID, "serial", d.Serial, "field", change.Field, "previous", change.Previous, "current", change.Current)
+				}
+			}
+		}
+		var snap *snapshot
+		snap, predicted = app.processSnapshot(ctx, s, o)
+		// Read once per tick rather than once per drone
+		violators := make(map[string]bool)
+		for _, serial := range s.violations.IDs() {
+			violators[serial] = true
+		}
+		app.processPositions(ctx, s, snap, violators)
+		app.processIncursions(ctx, s, predicted)
+	}
+	app.notify(s, o, predicted)
+}
+
+// notify archives the changes to the violations and the episodes since the
+// previous dispatch and sends them, the drone positions and the predicted
+// incursions to the notification sinks
+func (app *application) notify(s *site, o observation, predicted []dronePosition) {
+	sc, _ := app.cfg.Load().Site(s.cfg.ID)
+	var events []notify.Event
+	if o.changed {
+		events = s.tracker.Update(o.violations, o.time)
+	}
+	if o.reported {
+		// Violations have been looked up by now, so that the first episode of
+		// a violation belongs to its archived record
+		events = append(events, s.tracker.Episodes(sc.Zones, sc.Origin, o.drones, func(serial string) models.Pilot {
+			v, _ := s.violations.Get(serial)
+			return v.Pilot
+		}, o.time)...)
+	}
+	if o.reported {
+		started := 0
+		for _, event := range events {
+			if event.Type == notify.ViolationStarted {
+				started++
+			}
+		}
+		if err := s.rollups.Add(sc.Zones, o.drones, started, o.time); err != nil {
+			app.logger.Error("failed to store rollups", "site", s.cfg.ID, "err", err)
+		}
+	}
+	for _, event := range events {
+		app.logger.Debug("notifying", "site", s.cfg.ID, "event", event.ID, "type", event.Type, "pilot", event.Pilot.PilotID)
+		if err := app.history.Record(event); err != nil {
+			app.logger.Error("failed to archive violation", "site", s.cfg.ID, "event", event.ID, "err", err)
+		}
+		app.notifier.Notify(event)
+	}
+	if o.reported {
+		app.notifier.Notify(s.tracker.Positions(o.drones, o.time))
+	}
+	for _, p := range predicted {
+		app.logger.Info("incursion predicted", "site", s.cfg.ID, "serial", p.SerialNumber, "eta", *p.ETA)
+		app.notifier.Notify(s.tracker.Incursion(p.Drone, models.OriginDistance(sc.Zones, p.Drone), *p.ETA, o.time))
+	}
+}
+
+// processStatus shows or clears the offline banner when the circuit breaker
+// around the site's sensor changes state
+func (app *application) processStatus(s *site, state breaker.State, since time.Time) {
+	status := siteStatus{
+		Offline: state != breaker.Closed,
+		Since:   since,
+	}
+
+	s.homepageMutex.Lock()
+	changed := s.status != status
+	s.status = status
+	s.homepageMutex.Unlock()
+	if !changed {
+		return
+	}
+
+	if status.Offline {
+		app.logger.Warn("sensor offline", "site", s.cfg.ID, "since", since, "breaker", state.String())
+	} else {
+		app.logger.Info("sensor back online", "site", s.cfg.ID)
+	}
+
+	banner, err := app.renderHome(context.Background(), s, "status")
+	if err == nil {
+		app.publish(context.Background(), s, "status", banner)
+	}
+}
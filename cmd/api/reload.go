@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/persistence/encryption"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// watchConfig reloads the configuration on SIGHUP or when the file changes
+func (app *application) watchConfig(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	modified := modTime(path)
+	for {
+		select {
+		case <-hup:
+			app.reload(path)
+		case <-ticker.C:
+			if len(path) == 0 {
+				continue
+			}
+			if m := modTime(path); !m.Equal(modified) {
+				modified = m
+				app.reload(path)
+			}
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (app *application) reload(path string) {
+	next, err := config.Load(path, app.overrides)
+	if err != nil {
+		app.logger.Error("config reload failed, keeping the current configuration", "path", path, "err", err)
+		return
+	}
+
+	keyring, err := encryption.Load(next.Persistence.Encryption)
+	if err != nil {
+		app.logger.Error("config reload failed, keeping the current configuration", "path", path, "err", err)
+		return
+	}
+
+	current := app.cfg.Load()
+	if !reflect.DeepEqual(withoutReloadable(current), withoutReloadable(next)) {
+		app.logger.Warn("config has changes that require a restart to take effect", "path", path)
+	}
+	// Keep a level set through the admin endpoint unless the file changes it
+	if next.Log.Level != current.Log.Level {
+		app.logLevel.Set(next.Log.Level)
+	}
+
+	applied := reloadable(current, next)
+	app.cfg.Store(applied)
+	for _, s := range app.sites {
+		s.violations.SetTTL(applied.Persistence.TTL)
+		if r, ok := s.violations.(interface{ SetKeyring(*encryption.Keyring) }); ok {
+			r.SetKeyring(keyring)
+		}
+	}
+	app.logger.Info("config reloaded", "path", path)
+}
+
+// reloadable applies the settings of next that are safe to change while
+// running on top of current: zones and origins, poll timings, the persistence TTL and
+// encryption keys, the history retention and audit log and the log level
+func reloadable(current, next *config.Config) *config.Config {
+	applied := *current
+	applied.Log = next.Log
+	applied.Poll = next.Poll
+	applied.Persistence.TTL = next.Persistence.TTL
+	applied.Persistence.Encryption = next.Persistence.Encryption
+	applied.History.RetentionDays = next.History.RetentionDays
+	applied.History.AuditLog = next.History.AuditLog
+	applied.Sites = make([]config.Site, len(current.Sites))
+	for i, s := range current.Sites {
+		if ns, ok := next.Site(s.ID); ok {
+			// Zones in latitude and longitude depend on the origin
+			s.Zones, s.Origin = ns.Zones, ns.Origin
+		}
+		applied.Sites[i] = s
+	}
+	return &applied
+}
+
+func withoutReloadable(cfg *config.Config) config.Config {
+	c := *cfg
+	c.Log = config.Log{}
+	// The lookup pool is sized when the monitor starts
+	c.Poll = config.Poll{Workers: cfg.Poll.Workers}
+	c.Persistence.TTL = 0
+	c.Persistence.Encryption = config.Encryption{}
+	c.History = config.History{Path: cfg.History.Path}
+	c.Zones, c.Origin = nil, nil
+	c.Sites = make([]config.Site, len(cfg.Sites))
+	for i, s := range cfg.Sites {
+		s.Zones, s.Origin = nil, nil
+		c.Sites[i] = s
+	}
+	return c
+}
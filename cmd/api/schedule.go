@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"time"
+)
+
+// schedule decides how long to wait before the next poll based on what the
+// sensor reported about itself in the previous one.
+type schedule struct {
+	fallback   time.Duration
+	offset     time.Duration
+	maxBackoff time.Duration
+	interval   time.Duration
+	failures   int
+	deviceId   string
+	lastUptime int64
+}
+
+func newSchedule(fallback, offset, maxBackoff time.Duration) *schedule {
+	return &schedule{
+		fallback:   fallback,
+		offset:     offset,
+		maxBackoff: maxBackoff,
+		interval:   fallback,
+	}
+}
+
+// configure applies reloaded poll settings from the next poll on
+func (s *schedule) configure(poll config.Poll) {
+	if s.fallback == s.interval {
+		s.interval = poll.Interval
+	}
+	s.fallback = poll.Interval
+	s.offset = poll.Offset
+	s.maxBackoff = poll.MaxBackoff
+}
+
+func (s *schedule) next(logger *slog.Logger, report models.Report, err error) time.Duration {
+	if err != nil {
+		s.failures++
+		return s.backoff()
+	}
+	s.failures = 0
+
+	info := report.DeviceInformation
+	if s.restarted(info) {
+		logger.Warn("device restarted", "event", "device_restarted", "device", info.DeviceId, "uptime", info.UptimeSeconds, "previousUptime", s.lastUptime)
+	}
+	s.deviceId = info.DeviceId
+	s.lastUptime = info.UptimeSeconds
+
+	if info.UpdateIntervalMs > 0 {
+		s.interval = time.Duration(info.UpdateIntervalMs)*time.Millisecond + s.offset
+	} else {
+		s.interval = s.fallback
+	}
+	return s.interval
+}
+
+// Uptime going down for the same device means it was restarted between polls
+func (s *schedule) restarted(info models.DeviceInformation) bool {
+	return s.lastUptime > 0 && info.DeviceId == s.deviceId && info.UptimeSeconds < s.lastUptime
+}
+
+func (s *schedule) backoff() time.Duration {
+	wait := s.interval
+	for i := 0; i < s.failures && wait < s.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > s.maxBackoff {
+		wait = s.maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"reaktor-birdnest/internal/models"
+	"testing"
+	"time"
+)
+
+func TestScheduleFollowsUpdateInterval(t *testing.T) {
+	sched := newSchedule(2*time.Second, 100*time.Millisecond, time.Minute)
+
+	next := sched.next(slog.Default(), reportWithDevice("GUARDB1RD", 100, 1500), nil)
+	if next != 1600*time.Millisecond {
+		t.Errorf("Expected next poll in 1.6s, but was %v.", next)
+	}
+
+	next = sched.next(slog.Default(), reportWithDevice("GUARDB1RD", 102, 0), nil)
+	if next != 2*time.Second {
+		t.Errorf("Expected next poll to fall back to 2s, but was %v.", next)
+	}
+}
+
+func TestScheduleBacksOff(t *testing.T) {
+	sched := newSchedule(time.Second, 0, 5*time.Second)
+	failure := errors.New("unhealthy")
+
+	expected := []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, e := range expected {
+		if next := sched.next(slog.Default(), models.Report{}, failure); next != e {
+			t.Errorf("Expected backoff %d to be %v, but was %v.", i, e, next)
+		}
+	}
+
+	if next := sched.next(slog.Default(), reportWithDevice("GUARDB1RD", 10, 0), nil); next != time.Second {
+		t.Errorf("Expected recovered poll in 1s, but was %v.", next)
+	}
+}
+
+func TestScheduleDetectsRestart(t *testing.T) {
+	sched := newSchedule(time.Second, 0, time.Minute)
+
+	sched.next(slog.Default(), reportWithDevice("GUARDB1RD", 500, 0), nil)
+	if sched.restarted(reportWithDevice("GUARDB1RD", 600, 0).DeviceInformation) {
+		t.Errorf("Expected increasing uptime to not be a restart.")
+	}
+	if !sched.restarted(reportWithDevice("GUARDB1RD", 3, 0).DeviceInformation) {
+		t.Errorf("Expected decreasing uptime to be a restart.")
+	}
+	if sched.restarted(reportWithDevice("OTHER", 3, 0).DeviceInformation) {
+		t.Errorf("Expected a different device to not be a restart.")
+	}
+}
+
+func reportWithDevice(id string, uptime, interval int64) models.Report {
+	report := models.Report{}
+	report.DeviceInformation.DeviceId = id
+	report.DeviceInformation.UptimeSeconds = uptime
+	report.DeviceInformation.UpdateIntervalMs = interval
+	return report
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/tmaxmax/go-sse"
+	"reaktor-birdnest/internal/auth"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/devices"
+	"reaktor-birdnest/internal/heatmap"
+	"reaktor-birdnest/internal/interfaces"
+	"reaktor-birdnest/internal/models"
+	"reaktor-birdnest/internal/notify"
+	"reaktor-birdnest/internal/rollup"
+	"sync"
+	"time"
+)
+
+// site is a single monitored nest with its own sensor, persistence and
+// event stream
+type site struct {
+	cfg        config.Site
+	sseHandler *sse.Server
+	birdnest   interfaces.Birdnest
+	violations interfaces.Violations
+	// tracker turns dispatched violations into notification events
+	tracker *notify.Tracker
+	// backend names the persistence used for violations in logs
+	backend string
+	heatmap *heatmap.Heatmap
+	// devices are the drones seen by serial
+	devices *devices.Registry
+	rollups *rollup.Rollups
+
+	// The homepage of each role is rendered from the last dispatched
+	// violations and the sensor status whenever either changes
+	homepageMutex sync.RWMutex
+	homepages     map[auth.Role][]byte
+	shown         []models.Violation
+	status        siteStatus
+	// positions were last sent to the map
+	positions positionsPayload
+	// snapshot is the latest report, sightings are its drones by serial
+	snapshot  *snapshot
+	sightings map[string]sighting
+}
+
+type siteStatus struct {
+	Offline bool
+	// Since is when the sensor started failing
+	Since time.Time
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/tmaxmax/go-sse"
+	"math"
+	"net/http"
+	"reaktor-birdnest/internal/auth"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/geo"
+	"reaktor-birdnest/internal/models"
+	"time"
+)
+
+// positionsTopic is the event stream topic of the snapshots
+const positionsTopic = "positions"
+
+// snapshot is every drone of a report
+type snapshot struct {
+	Site   string          `json:"site"`
+	Time   time.Time       `json:"time"`
+	Drones []dronePosition `json:"drones"`
+}
+
+type dronePosition struct {
+	models.Drone
+	// BoundaryDistance to the edge of the nearest zone in meters, negative
+	// inside a zone
+	BoundaryDistance float64 `json:"boundaryDistance"`
+	// Heading in degrees clockwise from the sensor's y axis, null until the
+	// drone has been seen moving
+	Heading *float64 `json:"heading"`
+	// Velocity in meters per second since the previous report
+	Velocity *velocity `json:"velocity,omitempty"`
+	// ETA is the seconds until the drone enters a zone on its current course,
+	// set when within prediction.horizon
+	ETA *float64 `json:"eta,omitempty"`
+}
+
+type velocity struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// sighting is where a drone was seen last
+type sighting struct {
+	models.Sighting
+	heading *float64
+	// predicted is set while an incursion is predicted, so that it is
+	// notified once per approach
+	predicted bool
+}
+
+// processSnapshot sends the drones of a report with their distances,
+// headings and predicted incursions to the positions streams and keeps it as
+// the latest snapshot. It returns the drones whose incursion was predicted
+// for the first time.
+func (app *application) processSnapshot(ctx context.Context, s *site, o observation) (*snapshot, []dronePosition) {
+	cfg := app.cfg.Load()
+	sc, _ := cfg.Site(s.cfg.ID)
+	snap := &snapshot{Site: s.cfg.ID, Time: o.time, Drones: make([]dronePosition, 0, len(o.drones))}
+	sightings := make(map[string]sighting, len(o.drones))
+	var predicted []dronePosition
+
+	s.homepageMutex.Lock()
+	for _, drone := range o.drones {
+		p := dronePosition{Drone: drone, BoundaryDistance: models.BoundaryDistance(sc.Zones, drone)}
+		current := sighting{Sighting: models.SightingOf(drone, o.time)}
+		if previous, ok := s.sightings[drone.SerialNumber]; ok {
+			current.heading = previous.heading
+			dx, dy := current.X-previous.X, current.Y-previous.Y
+			if dx != 0 || dy != 0 {
+				heading := math.Mod(math.Atan2(dx, dy)*180/math.Pi+360, 360)
+				current.heading = &heading
+			}
+			if vx, vy, ok := current.Velocity(previous.Sighting); ok {
+				p.Velocity = &velocity{X: vx, Y: vy}
+			}
+
+			eta, ok := predictIncursion(cfg.Prediction, sc.Zones, current, p.Velocity)
+			if ok {
+				p.ETA = &eta
+				current.predicted = true
+				if !previous.predicted {
+					predicted = append(predicted, p)
+				}
+			}
+		}
+		p.Heading = current.heading
+		sightings[drone.SerialNumber] = current
+		snap.Drones = append(snap.Drones, p)
+	}
+	// Drones that left the sensor's range start over when they return
+	s.sightings = sightings
+	s.snapshot = snap
+	s.homepageMutex.Unlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return snap, predicted
+	}
+	_, span := tracer.Start(ctx, "sse.Publish")
+	defer span.End()
+	e := &sse.Message{Topic: positionsTopic}
+	e.SetName("snapshot")
+	e.AppendData(data)
+	s.sseHandler.Publish(e)
+	return snap, predicted
+}
+
+// locateDrones fills in the latitude and longitude of the drones when the
+// site has an origin
+func locateDrones(origin *geo.Reference, drones []models.Drone) []models.Drone {
+	for i := range drones {
+		d := &drones[i]
+		d.Lat, d.Lon = origin.Locate(d.PositionX/1000, d.PositionY/1000)
+	}
+	return drones
+}
+
+// predictIncursion returns the seconds until the drone enters the nearest
+// zone on its course, if it does within the horizon
+func predictIncursion(cfg config.Prediction, zones []models.Zone, at sighting, v *velocity) (float64, bool) {
+	if cfg.Horizon <= 0 || v == nil || math.Hypot(v.X, v.Y) < cfg.MinSpeed {
+		return 0, false
+	}
+	return models.Incursion(zones, at.X, at.Y, v.X, v.Y, cfg.Horizon.Seconds())
+}
+
+// servePositions streams the snapshots of a site to staff, at most one every
+// positions.minInterval or ?interval= if longer
+func (app *application) servePositions(w http.ResponseWriter, r *http.Request, s *site) {
+	if !app.requireStaff(w, r) {
+		return
+	}
+
+	interval := app.cfg.Load().Positions.MinInterval
+	if raw := r.URL.Query().Get("interval"); len(raw) != 0 {
+		requested, err := time.ParseDuration(raw)
+		if err != nil || requested < 0 {
+			writeError(w, http.StatusBadRequest, "interval must be a duration like 5s")
+			return
+		}
+		interval = max(interval, requested)
+	}
+	app.serveEvents(w, r, s, positionsTopic, interval)
+}
+
+// latestSnapshot returns the latest snapshot of the site given with ?site=,
+// which may be left out when only one site is configured
+func (app *application) latestSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !app.requireStaff(w, r) {
+		return
+	}
+
+	var s *site
+	if id := r.URL.Query().Get("site"); len(id) != 0 {
+		if s = app.site(id); s == nil {
+			writeError(w, http.StatusNotFound, "unknown site")
+			return
+		}
+	} else if len(app.sites) == 1 {
+		s = app.sites[0]
+	} else {
+		writeError(w, http.StatusBadRequest, "site must be given when several sites are monitored")
+		return
+	}
+
+	s.homepageMutex.RLock()
+	snap := s.snapshot
+	s.homepageMutex.RUnlock()
+	if snap == nil {
+		writeError(w, http.StatusNotFound, "no report received yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// requireStaff answers 401 to everybody but staff
+func (app *application) requireStaff(w http.ResponseWriter, r *http.Request) bool {
+	role, ok := app.role(w, r)
+	if !ok {
+		return false
+	}
+	if role != auth.Staff {
+		app.challenge(w)
+		writeError(w, http.StatusUnauthorized, "staff only")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reaktor-birdnest/internal/auth"
+	"reaktor-birdnest/internal/history"
+	"reaktor-birdnest/internal/stats"
+	"strconv"
+)
+
+// leaderboardSize is how many pilots the leaderboard page shows
+const leaderboardSize = 20
+
+type leaderboardData struct {
+	Pilots []stats.Pilot
+	// Models are the drone models with the most violations
+	Models []stats.Breakdown
+	// Site is empty for every site
+	Site  string
+	Login bool
+}
+
+// pilotStats returns the statistics of every pilot at every site, or at the
+// site given with ?site=, the most violations first. ?limit= returns only
+// the first pilots. Contact details are masked unless the caller is staff.
+func (app *application) pilotStats(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.role(w, r)
+	if !ok {
+		return
+	}
+	sites, ok := app.selectSites(w, r)
+	if !ok {
+		return
+	}
+	limit := 0
+	if raw := r.URL.Query().Get("limit"); len(raw) != 0 {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, app.offenders(role, sites, limit))
+}
+
+// leaderboard serves the page of the pilots with the most violations
+func (app *application) leaderboard(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.role(w, r)
+	if !ok {
+		return
+	}
+	sites, ok := app.selectSites(w, r)
+	if !ok {
+		return
+	}
+
+	models := stats.Drones(app.offences(sites), stats.ByModel)
+	data := leaderboardData{
+		Pilots: app.offenders(role, sites, leaderboardSize),
+		Models: models[:min(len(models), leaderboardSize)],
+		Site:   r.URL.Query().Get("site"),
+		Login:  role == auth.Public && app.auth.BasicAuth(),
+	}
+	buf := new(bytes.Buffer)
+	if err := app.tmpl.ExecuteTemplate(buf, "leaderboard", data); err != nil {
+		app.logger.Error("failed to render leaderboard", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// offenders returns the first limit pilots of the sites as seen by the role,
+// all of them when limit is 0
+func (app *application) offenders(role auth.Role, sites []*site, limit int) []stats.Pilot {
+	pilots := stats.Pilots(app.offences(sites))
+	if limit > 0 && len(pilots) > limit {
+		pilots = pilots[:limit]
+	}
+	if role != auth.Staff {
+		for i := range pilots {
+			pilots[i].Pilot = pilots[i].Pilot.Masked()
+		}
+	}
+	return pilots
+}
+
+// offences returns the archived violations of the sites and the current ones
+// missing from the archive, such as those looked up but not dispatched yet
+func (app *application) offences(sites []*site) []history.Record {
+	ids := make(map[string]bool, len(sites))
+	for _, s := range sites {
+		ids[s.cfg.ID] = true
+	}
+	var records []history.Record
+	for _, r := range app.history.Records() {
+		if ids[r.Site] {
+			records = append(records, r)
+		}
+	}
+	for _, s := range sites {
+		for _, v := range s.violations.AsSlice() {
+			if _, ok := app.history.Ongoing(s.cfg.ID, v.Pilot.PilotID); !ok {
+				records = append(records, history.Record{Site: s.cfg.ID, Pilot: v.Pilot, ClosestDistance: v.ClosestDistance})
+			}
+		}
+	}
+	return records
+}
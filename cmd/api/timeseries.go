@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/rollup"
+)
+
+type timeseriesResponse struct {
+	Site       string          `json:"site"`
+	Resolution string          `json:"resolution"`
+	Buckets    []rollup.Bucket `json:"buckets"`
+}
+
+// rollupsKept is how many buckets of each resolution are kept
+func rollupsKept(cfg config.Rollups) map[string]int {
+	return map[string]int{
+		rollup.Minute: cfg.Minutes,
+		rollup.Hour:   cfg.Hours,
+		rollup.Day:    cfg.Days,
+	}
+}
+
+// timeseries returns the rollups of every site, or of the site given with
+// ?site=, at the ?resolution= minute, hour (the default) or day
+func (app *application) timeseries(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.role(w, r); !ok {
+		return
+	}
+	sites, ok := app.selectSites(w, r)
+	if !ok {
+		return
+	}
+	resolution := r.URL.Query().Get("resolution")
+	if len(resolution) == 0 {
+		resolution = rollup.Hour
+	}
+	if _, ok := rollup.Resolutions[resolution]; !ok {
+		writeError(w, http.StatusBadRequest, "resolution must be minute, hour or day")
+		return
+	}
+
+	series := make([]timeseriesResponse, 0, len(sites))
+	for _, s := range sites {
+		series = append(series, timeseriesResponse{
+			Site:       s.cfg.ID,
+			Resolution: resolution,
+			Buckets:    s.rollups.Series(resolution),
+		})
+	}
+	writeJSON(w, http.StatusOK, series)
+}
+
+// statsPage serves the charts of the site's rollups
+func (app *application) statsPage(w http.ResponseWriter, r *http.Request, s *site) {
+	if _, ok := app.role(w, r); !ok {
+		return
+	}
+
+	sc, _ := app.cfg.Load().Site(s.cfg.ID)
+	buf := new(bytes.Buffer)
+	if err := app.tmpl.ExecuteTemplate(buf, "timeseries", sc); err != nil {
+		app.logger.Error("failed to render stats", "site", s.cfg.ID, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
@@ -2,8 +2,8 @@ package reaktor_birdnest
 
 import "embed"
 
-// Embed html templates to binary
+// Embed html and email templates to binary
 // . and .. are not allowed in embed statement
 
-//go:embed ui/html
+//go:embed ui/html ui/email
 var TemplateFS embed.FS
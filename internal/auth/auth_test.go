@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"net/http/httptest"
+	"reaktor-birdnest/internal/config"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRole(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	a := New(config.Auth{
+		Tokens: []string{"0123456789abcdef"},
+		Users:  []config.User{{Username: "ranger", PasswordHash: string(hash)}},
+		OIDC:   config.OIDC{Issuer: "https://id.example.com", Audience: "birdnest", Secret: "oidc-secret"},
+	})
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	exp := now.Add(time.Hour).Unix()
+
+	tests := []struct {
+		name          string
+		authorization string
+		basic         []string
+		expected      Role
+		err           error
+	}{
+		{"anonymous", "", nil, Public, nil},
+		{"token", "Bearer 0123456789abcdef", nil, Staff, nil},
+		{"wrong token", "Bearer nope", nil, Public, ErrUnauthorized},
+		{"basic auth", "", []string{"ranger", "hunter2"}, Staff, nil},
+		{"wrong password", "", []string{"ranger", "hunter3"}, Public, ErrUnauthorized},
+		{"unknown user", "", []string{"poacher", "hunter2"}, Public, ErrUnauthorized},
+		{"id token", "Bearer " + idToken("oidc-secret", `{"iss":"https://id.example.com","aud":["birdnest"],"exp":`+strconv.FormatInt(exp, 10)+`}`), nil, Staff, nil},
+		{"expired id token", "Bearer " + idToken("oidc-secret", `{"iss":"https://id.example.com","aud":"birdnest","exp":`+strconv.FormatInt(now.Unix(), 10)+`}`), nil, Public, ErrUnauthorized},
+		{"id token for another audience", "Bearer " + idToken("oidc-secret", `{"iss":"https://id.example.com","aud":"other","exp":`+strconv.FormatInt(exp, 10)+`}`), nil, Public, ErrUnauthorized},
+		{"forged id token", "Bearer " + idToken("guess", `{"iss":"https://id.example.com","aud":"birdnest","exp":`+strconv.FormatInt(exp, 10)+`}`), nil, Public, ErrUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if len(test.authorization) != 0 {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		if test.basic != nil {
+			r.SetBasicAuth(test.basic[0], test.basic[1])
+		}
+
+		role, err := a.Role(r)
+		if role != test.expected || !errors.Is(err, test.err) {
+			t.Errorf("Expected %s to be %s with error %v, but was %s with %v.", test.name, test.expected, test.err, role, err)
+		}
+	}
+}
+
+func idToken(secret, claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	unsigned := encode([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + encode([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + encode(mac.Sum(nil))
+}
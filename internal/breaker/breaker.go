@@ -0,0 +1,154 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reaktor-birdnest/internal/interfaces"
+	"reaktor-birdnest/internal/models"
+	"sync"
+	"time"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	// Closed lets every request through
+	Closed State = iota
+	// Open fails requests without calling the upstream until the cooldown
+	// has passed
+	Open
+	// HalfOpen lets a single trial request through to probe the upstream
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker is an interfaces.Birdnest that stops calling an unhealthy
+// upstream after threshold consecutive failures to get a report
+type Breaker struct {
+	next      interfaces.Birdnest
+	threshold int
+	cooldown  time.Duration
+	onChange  func(state State, since time.Time)
+	now       func() time.Time
+
+	mut      sync.Mutex
+	state    State
+	failures int
+	// since is when the current streak of failures began
+	since    time.Time
+	openedAt time.Time
+	trial    bool
+}
+
+// New wraps next. onChange is called with the new state and the time the
+// upstream started failing whenever the state changes.
+func New(next interfaces.Birdnest, threshold int, cooldown time.Duration, onChange func(State, time.Time)) *Breaker {
+	if onChange == nil {
+		onChange = func(State, time.Time) {}
+	}
+	return &Breaker{
+		next:      next,
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+		now:       time.Now,
+	}
+}
+
+func (b *Breaker) GetReport(ctx context.Context, logger *slog.Logger) (models.Report, error) {
+	if err := b.allow(); err != nil {
+		return models.Report{}, err
+	}
+	report, err := b.next.GetReport(ctx, logger)
+	b.record(err)
+	return report, err
+}
+
+// GetDronePilot fails fast while the breaker is not closed but leaves the
+// failure streak to the reports: the pilot endpoint answers 404 for some
+// serials, which says nothing about the sensor's health
+func (b *Breaker) GetDronePilot(ctx context.Context, droneSerialNumber string) (models.Pilot, error) {
+	b.mut.Lock()
+	state := b.state
+	b.mut.Unlock()
+	if state != Closed {
+		return models.Pilot{}, ErrOpen
+	}
+	return b.next.GetDronePilot(ctx, droneSerialNumber)
+}
+
+// State returns the current state and when the upstream started failing
+func (b *Breaker) State() (State, time.Time) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.state, b.since
+}
+
+func (b *Breaker) allow() error {
+	b.mut.Lock()
+	switch b.state {
+	case Closed:
+		b.mut.Unlock()
+		return nil
+	case Open:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			b.mut.Unlock()
+			return ErrOpen
+		}
+		b.state = HalfOpen
+		b.trial = true
+		since := b.since
+		b.mut.Unlock()
+		b.onChange(HalfOpen, since)
+		return nil
+	default:
+		// Only one trial at a time while half-open
+		defer b.mut.Unlock()
+		if b.trial {
+			return ErrOpen
+		}
+		b.trial = true
+		return nil
+	}
+}
+
+func (b *Breaker) record(err error) {
+	b.mut.Lock()
+	previous := b.state
+	b.trial = false
+
+	if err == nil {
+		b.state = Closed
+		b.failures = 0
+	} else {
+		if b.failures == 0 {
+			b.since = b.now()
+		}
+		b.failures++
+		if b.state == HalfOpen || b.failures >= b.threshold {
+			b.state = Open
+			b.openedAt = b.now()
+		}
+	}
+
+	state, since := b.state, b.since
+	b.mut.Unlock()
+
+	if state != previous {
+		b.onChange(state, since)
+	}
+}
@@ -0,0 +1,119 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reaktor-birdnest/internal/models"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	upstream := &flakyBirdnest{err: errors.New("offline")}
+	var changes []State
+	b := New(upstream, 2, time.Minute, func(s State, since time.Time) {
+		changes = append(changes, s)
+	})
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.GetReport(context.Background(), slog.Default()); !errors.Is(err, upstream.err) {
+			t.Errorf("Expected call %d to reach the upstream, but got %v.", i, err)
+		}
+	}
+	if state, since := b.State(); state != Open || !since.Equal(now) {
+		t.Errorf("Expected breaker to be open since %v, but was %v since %v.", now, state, since)
+	}
+
+	if _, err := b.GetReport(context.Background(), slog.Default()); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected open breaker to fail fast, but got %v.", err)
+	}
+	if upstream.calls != 2 {
+		t.Errorf("Expected upstream to be called twice, but was called %d times.", upstream.calls)
+	}
+
+	// The trial after the cooldown succeeds
+	now = now.Add(time.Minute)
+	upstream.err = nil
+	if _, err := b.GetReport(context.Background(), slog.Default()); err != nil {
+		t.Errorf("Expected trial request to succeed, but got %v.", err)
+	}
+	if state, _ := b.State(); state != Closed {
+		t.Errorf("Expected breaker to be closed, but was %v.", state)
+	}
+
+	expected := []State{Open, HalfOpen, Closed}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected state changes %v, but were %v.", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected state changes %v, but were %v.", expected, changes)
+		}
+	}
+}
+
+func TestBreakerReopensOnFailedTrial(t *testing.T) {
+	upstream := &flakyBirdnest{err: errors.New("offline")}
+	b := New(upstream, 1, time.Minute, nil)
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	b.now = func() time.Time { return now }
+
+	b.GetReport(context.Background(), slog.Default())
+	now = now.Add(time.Minute)
+	b.GetReport(context.Background(), slog.Default())
+
+	state, since := b.State()
+	if state != Open {
+		t.Errorf("Expected breaker to open again, but was %v.", state)
+	}
+	if !since.Equal(start) {
+		t.Errorf("Expected outage to be dated to the first failure %v, but was %v.", start, since)
+	}
+}
+
+func TestPilotLookupsDoNotTrip(t *testing.T) {
+	upstream := &flakyBirdnest{err: errors.New("404 Not Found")}
+	b := New(upstream, 3, time.Minute, nil)
+
+	for i := 0; i < 5; i++ {
+		if _, err := b.GetDronePilot(context.Background(), "123"); !errors.Is(err, upstream.err) {
+			t.Errorf("Expected lookup %d to reach the upstream, but got %v.", i, err)
+		}
+	}
+	if state, _ := b.State(); state != Closed {
+		t.Errorf("Expected failed pilot lookups to leave the breaker closed, but was %v.", state)
+	}
+
+	// Nor does a found pilot reset the streak of failed reports
+	b.GetReport(context.Background(), slog.Default())
+	b.GetReport(context.Background(), slog.Default())
+	upstream.err = nil
+	b.GetDronePilot(context.Background(), "123")
+	upstream.err = errors.New("offline")
+	b.GetReport(context.Background(), slog.Default())
+	if state, _ := b.State(); state != Open {
+		t.Errorf("Expected the third failed report to open the breaker, but was %v.", state)
+	}
+	if _, err := b.GetDronePilot(context.Background(), "123"); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected lookups to fail fast while open, but got %v.", err)
+	}
+}
+
+type flakyBirdnest struct {
+	err   error
+	calls int
+}
+
+func (f *flakyBirdnest) GetReport(ctx context.Context, logger *slog.Logger) (models.Report, error) {
+	f.calls++
+	return models.Report{}, f.err
+}
+
+func (f *flakyBirdnest) GetDronePilot(ctx context.Context, droneSerialNumber string) (models.Pilot, error) {
+	f.calls++
+	return models.Pilot{}, f.err
+}
@@ -0,0 +1,782 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reaktor-birdnest/internal/geo"
+	"reaktor-birdnest/internal/models"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is prepended to the upper-cased yaml path of a key to get the
+// environment variable overriding it, e.g. BIRDNEST_POLL_INTERVAL
+const EnvPrefix = "BIRDNEST"
+
+const defaultUpstream = "https://assignments.reaktor.com/birdnest"
+
+type Config struct {
+	HTTP        HTTP        `yaml:"http"`
+	Log         Log         `yaml:"log"`
+	Poll        Poll        `yaml:"poll"`
+	Persistence Persistence `yaml:"persistence"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Breaker     Breaker     `yaml:"breaker"`
+	Validation  Validation  `yaml:"validation"`
+	Notify      Notify      `yaml:"notify"`
+	Auth        Auth        `yaml:"auth"`
+	History     History     `yaml:"history"`
+	Positions   Positions   `yaml:"positions"`
+	Prediction  Prediction  `yaml:"prediction"`
+	Heatmap     Heatmap     `yaml:"heatmap"`
+	Rollups     Rollups     `yaml:"rollups"`
+	Devices     Devices     `yaml:"devices"`
+	// Upstream, Zones and Origin describe the default site when Sites is
+	// empty
+	Upstream string         `yaml:"upstream"`
+	Zones    []models.Zone  `yaml:"zones"`
+	Origin   *geo.Reference `yaml:"origin"`
+	Sites    []Site         `yaml:"sites"`
+}
+
+type HTTP struct {
+	Port int `yaml:"port"`
+	// AdminToken enables the /admin endpoints for bearers of the token
+	AdminToken string `yaml:"adminToken"`
+}
+
+type Log struct {
+	Level slog.Level `yaml:"level"`
+}
+
+type Poll struct {
+	// Interval is used when the sensor does not report its own
+	Interval   time.Duration `yaml:"interval"`
+	Offset     time.Duration `yaml:"offset"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// TickTimeout bounds how long a tick waits for the report and pilot
+	// lookups, lookups still running afterwards finish in the background
+	TickTimeout   time.Duration `yaml:"tickTimeout"`
+	LookupTimeout time.Duration `yaml:"lookupTimeout"`
+	// Workers is the number of concurrent pilot lookups per site
+	Workers int `yaml:"workers"`
+	// Overrun decides what happens to a tick that is due while the previous
+	// one is still running: skip it, coalesce all missed ticks into one or
+	// queue every one of them, up to 10
+	Overrun string `yaml:"overrun"`
+}
+
+const (
+	OverrunSkip     = "skip"
+	OverrunCoalesce = "coalesce"
+	OverrunQueue    = "queue"
+)
+
+type Persistence struct {
+	TTL      time.Duration `yaml:"ttl"`
+	RedisURL string        `yaml:"redisUrl"`
+	// Codec encodes the values written to Redis, values written with another
+	// codec are still read
+	Codec      string     `yaml:"codec"`
+	Encryption Encryption `yaml:"encryption"`
+}
+
+const (
+	CodecJSON   = "json"
+	CodecGob    = "gob"
+	CodecBinary = "binary"
+)
+
+// Encryption seals the values stored in Redis with AES-256-GCM, it is off
+// when KeyID is empty
+type Encryption struct {
+	// KeyID names the key new values are encrypted with, the other keys only
+	// decrypt values written before a rotation
+	KeyID string `yaml:"keyId"`
+	Keys  []Key  `yaml:"keys"`
+}
+
+// Key is a base64 encoded 32 byte key read from an environment variable or
+// a file, so that it is not kept in the configuration itself
+type Key struct {
+	ID   string `yaml:"id"`
+	Env  string `yaml:"env"`
+	File string `yaml:"file"`
+}
+
+// Breaker stops polling an upstream that keeps failing
+type Breaker struct {
+	// FailureThreshold consecutive failures open the breaker
+	FailureThreshold int `yaml:"failureThreshold"`
+	// Cooldown is how long an open breaker waits before probing again
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+type Validation struct {
+	// Strict rejects a whole report when any drone in it is invalid, by
+	// default only the invalid drones are dropped
+	Strict bool `yaml:"strict"`
+}
+
+// Notify sends violation events to external systems
+type Notify struct {
+	// Thresholds are distances in meters, stricter than the zone radius,
+	// that send an event when a pilot's closest distance drops below them
+	Thresholds []float64 `yaml:"thresholds"`
+	// Outbox is the directory pending deliveries are kept in until they
+	// succeed so that a restart does not lose them
+	Outbox string `yaml:"outbox"`
+	// Failed deliveries are retried with exponential backoff starting from
+	// InitialBackoff until MaxAttempts deliveries have failed
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	Webhooks       []Webhook     `yaml:"webhooks"`
+	Email          Email         `yaml:"email"`
+	MQTT           MQTT          `yaml:"mqtt"`
+}
+
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Secret signs the payload with HMAC-SHA256
+	Secret string `yaml:"secret"`
+	// Events, Sites and MaxDistance filter the events sent to the endpoint.
+	// Events defaults to violation.started and violation.closer, the other
+	// filters let everything through when empty.
+	Events      []string `yaml:"events"`
+	Sites       []string `yaml:"sites"`
+	MaxDistance float64  `yaml:"maxDistance"`
+}
+
+// Email sends alerts over SMTP, it is off when Addr is empty
+type Email struct {
+	// Addr is the host:port of the SMTP server
+	Addr     string   `yaml:"addr"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// Immediate sends an email for every new violator
+	Immediate bool `yaml:"immediate"`
+	// Digest summarises the violations of the past hour or day
+	Digest string `yaml:"digest"`
+}
+
+const (
+	DigestOff    = ""
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+)
+
+// MQTT publishes violation events and drone positions, it is off when
+// Broker is empty
+type MQTT struct {
+	// Broker is the URL of the broker, e.g. tcp://localhost:1883
+	Broker   string `yaml:"broker"`
+	ClientID string `yaml:"clientId"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	QoS      byte   `yaml:"qos"`
+	// MaskPilots publishes the pilots masked like to the public, anyone
+	// subscribed to the broker sees them
+	MaskPilots bool   `yaml:"maskPilots"`
+	Topics     Topics `yaml:"topics"`
+}
+
+// Topics are templates where {site}, {event}, {pilot} and {serial} are
+// replaced by the values of the message. An empty topic is not published.
+type Topics struct {
+	// Events receives violation added, updated and expired events
+	Events string `yaml:"events"`
+	// State holds the current violation of a pilot as a retained message
+	// that is cleared when the violation expires
+	State string `yaml:"state"`
+	// Positions receives the position of every drone in every report
+	Positions string `yaml:"positions"`
+}
+
+// Auth grants the staff role, which sees full pilot contact details, to
+// callers with any of the credentials. Everybody else sees masked details.
+type Auth struct {
+	// Tokens are accepted as "Authorization: Bearer <token>"
+	Tokens []string `yaml:"tokens"`
+	// Users log in with basic auth
+	Users []User `yaml:"users"`
+	OIDC  OIDC   `yaml:"oidc"`
+}
+
+type User struct {
+	Username string `yaml:"username"`
+	// PasswordHash is a bcrypt hash, e.g. from htpasswd -nbB
+	PasswordHash string `yaml:"passwordHash"`
+}
+
+// OIDC accepts HS256 signed ID tokens as bearer tokens, it is off when
+// Secret is empty
+type OIDC struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	Secret   string `yaml:"secret"`
+}
+
+// Positions streams every drone of every report to staff
+type Positions struct {
+	// MinInterval is the least time between two events of a positions
+	// stream, clients may ask for a longer one with ?interval=
+	MinInterval time.Duration `yaml:"minInterval"`
+}
+
+// Prediction warns of drones heading into a zone, estimating their velocity
+// from consecutive reports
+type Prediction struct {
+	// Horizon is how far ahead incursions are predicted, 0 turns prediction
+	// off
+	Horizon time.Duration `yaml:"horizon"`
+	// MinSpeed in meters per second ignores drones hovering in place, whose
+	// direction is mostly noise
+	MinSpeed float64 `yaml:"minSpeed"`
+}
+
+// Heatmap counts the drones seen in each cell of a grid over the sensor area
+type Heatmap struct {
+	// CellSize is the side of a cell in meters
+	CellSize float64 `yaml:"cellSize"`
+	// Bucket is the time resolution of the heatmap's window
+	Bucket time.Duration `yaml:"bucket"`
+	// Retention is the longest window, older counts are dropped
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Rollups count the drones and violations of each site per minute, hour and
+// day
+type Rollups struct {
+	// Backend keeps the closed buckets across restarts: memory keeps them
+	// nowhere, file in Path and redis in persistence.redisUrl
+	Backend string `yaml:"backend"`
+	// Path is the directory of the file backend
+	Path string `yaml:"path"`
+	// Minutes, Hours and Days are how many buckets of each are kept
+	Minutes int `yaml:"minutes"`
+	Hours   int `yaml:"hours"`
+	Days    int `yaml:"days"`
+}
+
+const (
+	RollupsMemory = "memory"
+	RollupsFile   = "file"
+	RollupsRedis  = "redis"
+)
+
+// Devices keeps the manufacturer, model, firmware and addresses of every
+// drone by serial, in memory
+type Devices struct {
+	// Retention is how long a drone is remembered after it was last seen
+	Retention time.Duration `yaml:"retention"`
+}
+
+// History archives every violation after it has expired from persistence
+type History struct {
+	// Path is the JSON lines file of the archive, which is kept in memory
+	// only when empty
+	Path string `yaml:"path"`
+	// RetentionDays anonymises the records of violations that ended more
+	// days ago, 0 keeps the pilots forever
+	RetentionDays int `yaml:"retentionDays"`
+	// AuditLog is the JSON lines file erasures are recorded in, they are
+	// only logged when empty
+	AuditLog string `yaml:"auditLog"`
+}
+
+type Tracing struct {
+	// Endpoint is the OTLP/HTTP collector URL, tracing is off when empty
+	Endpoint    string  `yaml:"endpoint"`
+	ServiceName string  `yaml:"serviceName"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+type Site struct {
+	ID        string        `yaml:"id"`
+	Name      string        `yaml:"name"`
+	Upstream  string        `yaml:"upstream"`
+	Namespace string        `yaml:"namespace"`
+	Zones     []models.Zone `yaml:"zones"`
+	// Origin places the site on the globe, GeoJSON exports and zones given
+	// in latitude and longitude need it
+	Origin *geo.Reference `yaml:"origin"`
+}
+
+func Default() *Config {
+	return &Config{
+		HTTP: HTTP{
+			Port: 8080,
+		},
+		Poll: Poll{
+			Interval:      2 * time.Second,
+			Offset:        100 * time.Millisecond,
+			MaxBackoff:    time.Minute,
+			TickTimeout:   1500 * time.Millisecond,
+			LookupTimeout: 10 * time.Second,
+			Workers:       8,
+			Overrun:       OverrunCoalesce,
+		},
+		Persistence: Persistence{
+			TTL:   10 * time.Minute,
+			Codec: CodecJSON,
+		},
+		Tracing: Tracing{
+			ServiceName: "reaktor-birdnest",
+			SampleRatio: 1,
+		},
+		Breaker: Breaker{
+			FailureThreshold: 3,
+			Cooldown:         30 * time.Second,
+		},
+		Prediction: Prediction{
+			Horizon:  30 * time.Second,
+			MinSpeed: 0.5,
+		},
+		Heatmap: Heatmap{
+			CellSize:  10,
+			Bucket:    10 * time.Minute,
+			Retention: 24 * time.Hour,
+		},
+		Devices: Devices{
+			Retention: 7 * 24 * time.Hour,
+		},
+		Rollups: Rollups{
+			Backend: RollupsMemory,
+			Minutes: 24 * 60,
+			Hours:   30 * 24,
+			Days:    365,
+		},
+		Notify: Notify{
+			InitialBackoff: time.Second,
+			MaxBackoff:     5 * time.Minute,
+			MaxAttempts:    10,
+			MQTT: MQTT{
+				ClientID:   "reaktor-birdnest",
+				QoS:        1,
+				MaskPilots: true,
+				Topics: Topics{
+					Events:    "birdnest/{site}/violations/events/{event}",
+					State:     "birdnest/{site}/violations/current/{pilot}",
+					Positions: "birdnest/{site}/drones/{serial}",
+				},
+			},
+		},
+		Upstream: defaultUpstream,
+		Zones: []models.Zone{
+			{OriginX: 250000, OriginY: 250000, Radius: 100},
+		},
+	}
+}
+
+// Override changes the configuration after the file and the environment,
+// before it is validated
+type Override func(*Config) error
+
+// Load reads the configuration from defaults, the optional YAML file at path,
+// environment overrides and the given overrides, in that order, and validates
+// the result
+func Load(path string, overrides ...Override) (*Config, error) {
+	cfg := Default()
+
+	if len(path) != 0 {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), EnvPrefix); err != nil {
+		return nil, err
+	}
+	// Kept for compatibility with the deployment environment
+	if err := applyEnvValue("PORT", &cfg.HTTP.Port); err != nil {
+		return nil, err
+	}
+	if err := applyEnvValue("REDIS_URL", &cfg.Persistence.RedisURL); err != nil {
+		return nil, err
+	}
+	for _, override := range overrides {
+		if override == nil {
+			continue
+		}
+		if err := override(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.fillSites()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.georeference()
+	return cfg, nil
+}
+
+// applyEnv walks the struct and decodes every variable that is set into
+// the field it overrides
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if len(name) == 0 {
+			continue
+		}
+		key := prefix + "_" + strings.ToUpper(name)
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(fv, key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := applyEnvValue(key, fv.Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyEnvValue(key string, target any) error {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	if s, ok := target.(*string); ok {
+		*s = value
+		return nil
+	}
+	if err := yaml.Unmarshal([]byte(value), target); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	return nil
+}
+
+// fillSites turns the top-level upstream and zones into the default site and
+// fills in optional site fields
+func (c *Config) fillSites() {
+	if len(c.Sites) == 0 {
+		c.Sites = []Site{{
+			ID:       "default",
+			Name:     "Project Birdnest",
+			Upstream: c.Upstream,
+			Zones:    c.Zones,
+			Origin:   c.Origin,
+		}}
+	}
+
+	for i := range c.Sites {
+		s := &c.Sites[i]
+		if len(s.Name) == 0 {
+			s.Name = s.ID
+		}
+		if len(s.Namespace) == 0 {
+			s.Namespace = s.ID
+		}
+		if len(s.Upstream) == 0 {
+			s.Upstream = c.Upstream
+		}
+	}
+}
+
+// georeference converts the zones given in latitude and longitude to sensor
+// coordinates and the others the other way round
+func (c *Config) georeference() {
+	for _, s := range c.Sites {
+		if s.Origin == nil {
+			continue
+		}
+		for i := range s.Zones {
+			z := &s.Zones[i]
+			if z.Lat != nil {
+				x, y := s.Origin.FromWGS84(*z.Lat, *z.Lon)
+				z.OriginX, z.OriginY = x*1000, y*1000
+				continue
+			}
+			z.Lat, z.Lon = s.Origin.Locate(z.OriginX/1000, z.OriginY/1000)
+		}
+	}
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.HTTP.Port <= 0 || c.HTTP.Port > 65535 {
+		invalid("http.port must be between 1 and 65535, got %d", c.HTTP.Port)
+	}
+	if c.Poll.Interval <= 0 {
+		invalid("poll.interval must be positive, got %v", c.Poll.Interval)
+	}
+	if c.Poll.Offset < 0 {
+		invalid("poll.offset must not be negative, got %v", c.Poll.Offset)
+	}
+	if c.Poll.MaxBackoff < c.Poll.Interval {
+		invalid("poll.maxBackoff must be at least poll.interval, got %v", c.Poll.MaxBackoff)
+	}
+	if c.Poll.TickTimeout <= 0 {
+		invalid("poll.tickTimeout must be positive, got %v", c.Poll.TickTimeout)
+	}
+	if c.Poll.LookupTimeout <= 0 {
+		invalid("poll.lookupTimeout must be positive, got %v", c.Poll.LookupTimeout)
+	}
+	if c.Poll.Workers <= 0 {
+		invalid("poll.workers must be positive, got %d", c.Poll.Workers)
+	}
+	switch c.Poll.Overrun {
+	case OverrunSkip, OverrunCoalesce, OverrunQueue:
+	default:
+		invalid("poll.overrun must be one of %s, %s or %s, got %q", OverrunSkip, OverrunCoalesce, OverrunQueue, c.Poll.Overrun)
+	}
+	if c.Persistence.TTL <= 0 {
+		invalid("persistence.ttl must be positive, got %v", c.Persistence.TTL)
+	}
+	if len(c.Persistence.RedisURL) != 0 {
+		if _, err := redis.ParseURL(c.Persistence.RedisURL); err != nil {
+			invalid("persistence.redisUrl is malformed: %v", err)
+		}
+	}
+	switch c.Persistence.Codec {
+	case CodecJSON, CodecGob, CodecBinary:
+	default:
+		invalid("persistence.codec must be %s, %s or %s, got %q", CodecJSON, CodecGob, CodecBinary, c.Persistence.Codec)
+	}
+	if enc := c.Persistence.Encryption; len(enc.KeyID) != 0 || len(enc.Keys) != 0 {
+		ids := make(map[string]bool, len(enc.Keys))
+		for i, k := range enc.Keys {
+			path := fmt.Sprintf("persistence.encryption.keys[%d]", i)
+			if len(k.ID) == 0 || len(k.ID) > 255 {
+				invalid("%s.id must be 1 to 255 characters", path)
+			} else if ids[k.ID] {
+				invalid("%s.id %q is used by another key", path, k.ID)
+			}
+			ids[k.ID] = true
+			if (len(k.Env) == 0) == (len(k.File) == 0) {
+				invalid("%s must set exactly one of env and file", path)
+			}
+		}
+		if !ids[enc.KeyID] {
+			invalid("persistence.encryption.keyId must name one of the keys, got %q", enc.KeyID)
+		}
+	}
+	if c.Breaker.FailureThreshold <= 0 {
+		invalid("breaker.failureThreshold must be positive, got %d", c.Breaker.FailureThreshold)
+	}
+	if c.Breaker.Cooldown <= 0 {
+		invalid("breaker.cooldown must be positive, got %v", c.Breaker.Cooldown)
+	}
+	if len(c.Tracing.Endpoint) != 0 && !isHTTPURL(c.Tracing.Endpoint) {
+		invalid("tracing.endpoint must be an absolute http(s) URL, got %q", c.Tracing.Endpoint)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sampleRatio must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+	for i, t := range c.Notify.Thresholds {
+		if t <= 0 {
+			invalid("notify.thresholds[%d] must be positive, got %v", i, t)
+		}
+	}
+	if len(c.Notify.Webhooks) != 0 && len(c.Notify.Outbox) == 0 {
+		invalid("notify.outbox must be set when webhooks are configured")
+	}
+	if c.Notify.InitialBackoff <= 0 {
+		invalid("notify.initialBackoff must be positive, got %v", c.Notify.InitialBackoff)
+	}
+	if c.Notify.MaxBackoff < c.Notify.InitialBackoff {
+		invalid("notify.maxBackoff must be at least notify.initialBackoff, got %v", c.Notify.MaxBackoff)
+	}
+	if c.Notify.MaxAttempts <= 0 {
+		invalid("notify.maxAttempts must be positive, got %d", c.Notify.MaxAttempts)
+	}
+	webhooks := make(map[string]bool, len(c.Notify.Webhooks))
+	for i, w := range c.Notify.Webhooks {
+		path := fmt.Sprintf("notify.webhooks[%d]", i)
+		if !isHTTPURL(w.URL) {
+			invalid("%s.url must be an absolute http(s) URL, got %q", path, w.URL)
+		} else if webhooks[w.URL] {
+			invalid("%s.url %q is used by another webhook", path, w.URL)
+		}
+		webhooks[w.URL] = true
+		if len(w.Secret) == 0 {
+			invalid("%s.secret must be set", path)
+		}
+		if w.MaxDistance < 0 {
+			invalid("%s.maxDistance must not be negative, got %v", path, w.MaxDistance)
+		}
+	}
+	if email := c.Notify.Email; len(email.Addr) != 0 {
+		if _, _, err := net.SplitHostPort(email.Addr); err != nil {
+			invalid("notify.email.addr must be host:port, got %q", email.Addr)
+		}
+		if len(email.From) == 0 {
+			invalid("notify.email.from must be set")
+		}
+		if len(email.To) == 0 {
+			invalid("notify.email.to must contain at least one address")
+		}
+		switch email.Digest {
+		case DigestOff, DigestHourly, DigestDaily:
+		default:
+			invalid("notify.email.digest must be empty, %s or %s, got %q", DigestHourly, DigestDaily, email.Digest)
+		}
+		if !email.Immediate && email.Digest == DigestOff {
+			invalid("notify.email must enable immediate alerts or a digest")
+		}
+	}
+	if mqtt := c.Notify.MQTT; len(mqtt.Broker) != 0 {
+		if u, err := url.Parse(mqtt.Broker); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			invalid("notify.mqtt.broker must be a URL like tcp://host:1883, got %q", mqtt.Broker)
+		}
+		if len(mqtt.ClientID) == 0 {
+			invalid("notify.mqtt.clientId must be set")
+		}
+		if mqtt.QoS > 2 {
+			invalid("notify.mqtt.qos must be 0, 1 or 2, got %d", mqtt.QoS)
+		}
+	}
+	for i, token := range c.Auth.Tokens {
+		if len(token) < 16 {
+			invalid("auth.tokens[%d] must be at least 16 characters", i)
+		}
+	}
+	for i, u := range c.Auth.Users {
+		if len(u.Username) == 0 {
+			invalid("auth.users[%d].username must be set", i)
+		}
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			invalid("auth.users[%d].passwordHash must be a bcrypt hash: %v", i, err)
+		}
+	}
+	if len(c.Auth.OIDC.Secret) != 0 && (len(c.Auth.OIDC.Issuer) == 0 || len(c.Auth.OIDC.Audience) == 0) {
+		invalid("auth.oidc.issuer and auth.oidc.audience must be set with auth.oidc.secret")
+	}
+	if c.Positions.MinInterval < 0 {
+		invalid("positions.minInterval must not be negative, got %v", c.Positions.MinInterval)
+	}
+	if c.Prediction.Horizon < 0 {
+		invalid("prediction.horizon must not be negative, got %v", c.Prediction.Horizon)
+	}
+	if c.Prediction.MinSpeed < 0 {
+		invalid("prediction.minSpeed must not be negative, got %v", c.Prediction.MinSpeed)
+	}
+	if c.Heatmap.CellSize < 1 || c.Heatmap.CellSize > 500 {
+		invalid("heatmap.cellSize must be between 1 and 500 meters, got %v", c.Heatmap.CellSize)
+	}
+	if c.Heatmap.Bucket <= 0 {
+		invalid("heatmap.bucket must be positive, got %v", c.Heatmap.Bucket)
+	}
+	if c.Heatmap.Retention < c.Heatmap.Bucket {
+		invalid("heatmap.retention must be at least heatmap.bucket, got %v", c.Heatmap.Retention)
+	}
+	switch c.Rollups.Backend {
+	case RollupsMemory:
+	case RollupsFile:
+		if len(c.Rollups.Path) == 0 {
+			invalid("rollups.path is required with the file backend")
+		}
+	case RollupsRedis:
+		if len(c.Persistence.RedisURL) == 0 {
+			invalid("rollups.backend redis requires persistence.redisUrl")
+		}
+	default:
+		invalid("rollups.backend must be %s, %s or %s, got %q", RollupsMemory, RollupsFile, RollupsRedis, c.Rollups.Backend)
+	}
+	if c.Rollups.Minutes < 1 || c.Rollups.Hours < 1 || c.Rollups.Days < 1 {
+		invalid("rollups.minutes, hours and days must be at least 1")
+	}
+	if c.Devices.Retention <= 0 {
+		invalid("devices.retention must be positive, got %v", c.Devices.Retention)
+	}
+	if c.History.RetentionDays < 0 {
+		invalid("history.retentionDays must not be negative, got %d", c.History.RetentionDays)
+	}
+
+	seen := make(map[string]bool, len(c.Sites))
+	// Sites sharing a namespace would share, and flush, their Redis keys
+	namespaces := make(map[string]bool, len(c.Sites))
+	for i, s := range c.Sites {
+		path := fmt.Sprintf("sites[%d]", i)
+		if len(s.ID) == 0 {
+			invalid("%s.id must be set", path)
+		} else if seen[s.ID] {
+			invalid("%s.id %q is used by another site", path, s.ID)
+		}
+		seen[s.ID] = true
+		if namespaces[s.Namespace] {
+			invalid("%s.namespace %q is used by another site", path, s.Namespace)
+		}
+		namespaces[s.Namespace] = true
+
+		if !isHTTPURL(s.Upstream) {
+			invalid("%s.upstream must be an absolute http(s) URL, got %q", path, s.Upstream)
+		}
+		if len(s.Zones) == 0 {
+			invalid("%s.zones must contain at least one zone", path)
+		}
+		for j, z := range s.Zones {
+			if z.Radius <= 0 {
+				invalid("%s.zones[%d].radius must be positive, got %v", path, j, z.Radius)
+			}
+			if z.Lat == nil && z.Lon == nil {
+				continue
+			}
+			switch {
+			case z.Lat == nil || z.Lon == nil:
+				invalid("%s.zones[%d] must have both lat and lon", path, j)
+			case z.OriginX != 0 || z.OriginY != 0:
+				invalid("%s.zones[%d] must have either originX and originY or lat and lon", path, j)
+			case s.Origin == nil:
+				invalid("%s.zones[%d] needs %s.origin to be given in lat and lon", path, j, path)
+			case *z.Lat < -90 || *z.Lat > 90 || *z.Lon < -180 || *z.Lon > 180:
+				invalid("%s.zones[%d] must have a latitude between -90 and 90 and a longitude between -180 and 180, got %v, %v", path, j, *z.Lat, *z.Lon)
+			}
+		}
+		if o := s.Origin; o != nil {
+			if o.Lat < -90 || o.Lat > 90 || o.Lon < -180 || o.Lon > 180 {
+				invalid("%s.origin must be a latitude between -90 and 90 and a longitude between -180 and 180, got %v, %v", path, o.Lat, o.Lon)
+			}
+			if o.Scale < 0 {
+				invalid("%s.origin.scale must not be negative, got %v", path, o.Scale)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) != 0
+}
+
+// Site returns the configuration of the site with the given id
+func (c *Config) Site(id string) (Site, bool) {
+	for _, s := range c.Sites {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return Site{}, false
+}
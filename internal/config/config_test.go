@@ -0,0 +1,178 @@
+package config
+
+import (
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, `
+poll:
+  interval: 3s
+persistence:
+  ttl: 5m
+sites:
+  - id: north
+    upstream: http://localhost:9999/birdnest
+    zones:
+      - {originX: 1000, originY: 2000, radius: 50}
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected config to load, but got %v.", err)
+	}
+
+	if cfg.Poll.Interval != 3*time.Second {
+		t.Errorf("Expected poll interval to be 3s, but was %v.", cfg.Poll.Interval)
+	}
+	if cfg.Poll.MaxBackoff != time.Minute {
+		t.Errorf("Expected default max backoff to be kept, but was %v.", cfg.Poll.MaxBackoff)
+	}
+
+	north, ok := cfg.Site("north")
+	if !ok {
+		t.Fatalf("Expected site north to exist.")
+	}
+	if north.Namespace != "north" || north.Name != "north" {
+		t.Errorf("Expected namespace and name to default to the id, but were %q and %q.", north.Namespace, north.Name)
+	}
+	if len(north.Zones) != 1 || north.Zones[0].Radius != 50 {
+		t.Errorf("Expected one zone with radius 50, but was %v.", north.Zones)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	path := writeConfig(t, "poll:\n  interval: 3s\n")
+	t.Setenv("BIRDNEST_POLL_INTERVAL", "4s")
+	t.Setenv("BIRDNEST_ZONES", "[{originX: 1, originY: 2, radius: 3}]")
+	t.Setenv("PORT", "9090")
+	t.Setenv("BIRDNEST_LOG_LEVEL", "debug")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected config to load, but got %v.", err)
+	}
+
+	if cfg.Poll.Interval != 4*time.Second {
+		t.Errorf("Expected poll interval to be 4s, but was %v.", cfg.Poll.Interval)
+	}
+	if cfg.Log.Level != slog.LevelDebug {
+		t.Errorf("Expected log level to be debug, but was %v.", cfg.Log.Level)
+	}
+	if cfg.HTTP.Port != 9090 {
+		t.Errorf("Expected port to be 9090, but was %d.", cfg.HTTP.Port)
+	}
+	site, _ := cfg.Site("default")
+	if len(site.Zones) != 1 || site.Zones[0].Radius != 3 {
+		t.Errorf("Expected default site to use the zone from the environment, but was %v.", site.Zones)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	path := writeConfig(t, `
+persistence:
+  redisUrl: "mysql://nope"
+  encryption:
+    keyId: new
+    keys:
+      - {id: old, env: OLD_KEY, file: /run/old}
+zones:
+  - {originX: 0, originY: 0, radius: -5}
+notify:
+  webhooks:
+    - url: http://localhost/hook
+`)
+
+	_, err := Load(path)
+	if err == nil {
+		t.Fatalf("Expected validation to fail.")
+	}
+
+	for _, expected := range []string{
+		"persistence.redisUrl is malformed",
+		"sites[0].zones[0].radius must be positive",
+		"notify.outbox must be set",
+		"notify.webhooks[0].secret must be set",
+		"persistence.encryption.keys[0] must set exactly one of env and file",
+		"persistence.encryption.keyId must name one of the keys",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain %q, but was %q.", expected, err.Error())
+		}
+	}
+}
+
+func TestDuplicateNamespaces(t *testing.T) {
+	path := writeConfig(t, `
+sites:
+  - id: north
+    upstream: http://localhost:9999/birdnest
+    zones: [{originX: 1000, originY: 2000, radius: 50}]
+  - id: south
+    namespace: north
+    upstream: http://localhost:9999/birdnest
+    zones: [{originX: 1000, originY: 2000, radius: 50}]
+`)
+
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), `sites[1].namespace "north" is used by another site`) {
+		t.Errorf("Expected sites sharing a namespace to be rejected, but was %v.", err)
+	}
+}
+
+func TestGeoreferencedZones(t *testing.T) {
+	north := `
+sites:
+  - id: north
+    upstream: http://localhost:9999/birdnest
+    origin: {lat: 60, lon: 25, rotation: 90}
+    zones:
+      - {lat: 60, lon: 25.008983, radius: 100}
+      - {originX: 250000, originY: 0, radius: 50}
+`
+	_, err := Load(writeConfig(t, north+`
+  - id: south
+    upstream: http://localhost:9999/birdnest
+    zones:
+      - {lat: 60, lon: 25, radius: 100}
+`))
+	if err == nil || !strings.Contains(err.Error(), "sites[1].zones[0] needs sites[1].origin to be given in lat and lon") {
+		t.Fatalf("Expected zones in lat and lon to need an origin, but was %v.", err)
+	}
+
+	cfg, err := Load(writeConfig(t, north))
+	if err != nil {
+		t.Fatalf("Expected config to load, but got %v.", err)
+	}
+	site, _ := cfg.Site("north")
+	// With the y axis pointing east, 500 m east is y = 500, give or take the
+	// rounding of the longitude
+	if z := site.Zones[0]; math.Abs(z.OriginX) > 10 || math.Abs(z.OriginY-500000) > 10 {
+		t.Errorf("Expected the zone at 0, 500000, but was %v, %v.", z.OriginX, z.OriginY)
+	}
+	if z := site.Zones[1]; z.Lat == nil || math.Abs(*z.Lat-59.997754) > 1e-6 || math.Abs(*z.Lon-25) > 1e-6 {
+		t.Errorf("Expected the zone's latitude and longitude to be filled in, but was %v, %v.", z.Lat, z.Lon)
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	path := writeConfig(t, "pol:\n  interval: 3s\n")
+
+	if _, err := Load(path); err == nil {
+		t.Errorf("Expected unknown keys to be rejected.")
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
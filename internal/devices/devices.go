@@ -0,0 +1,117 @@
+package devices
+
+import (
+	"cmp"
+	"reaktor-birdnest/internal/models"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxChanges is how many changes are kept per device, the latest ones
+const maxChanges = 10
+
+// Device is what a site's sensor last reported of a drone
+type Device struct {
+	Serial       string    `json:"serial"`
+	Manufacturer string    `json:"manufacturer"`
+	Model        string    `json:"model"`
+	Firmware     string    `json:"firmware"`
+	Mac          string    `json:"mac"`
+	Ipv4         string    `json:"ipv4"`
+	Ipv6         string    `json:"ipv6"`
+	FirstSeen    time.Time `json:"firstSeen"`
+	LastSeen     time.Time `json:"lastSeen"`
+	// Changed flags a drone whose MAC or IP address changed between
+	// sightings, which may be spoofed
+	Changed bool `json:"changed"`
+	// Changes are the latest changes of the addresses, oldest first
+	Changes []Change `json:"changes"`
+}
+
+// Change is an address of a drone that differs from the previous sighting
+type Change struct {
+	Time time.Time `json:"time"`
+	// Field is mac, ipv4 or ipv6
+	Field    string `json:"field"`
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+// Registry keeps the devices of a site by serial, forgetting those not seen
+// for the retention
+type Registry struct {
+	retention time.Duration
+
+	mut     sync.RWMutex
+	devices map[string]*Device
+}
+
+func New(retention time.Duration) *Registry {
+	return &Registry{retention: retention, devices: make(map[string]*Device)}
+}
+
+// Observe updates the devices with the drones of a report and returns those
+// whose addresses changed with it
+func (r *Registry) Observe(drones []models.Drone, now time.Time) []Device {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var changed []Device
+	for _, drone := range drones {
+		d, seen := r.devices[drone.SerialNumber]
+		if !seen {
+			d = &Device{Serial: drone.SerialNumber, FirstSeen: now, Changes: make([]Change, 0)}
+			r.devices[drone.SerialNumber] = d
+		}
+		before := len(d.Changes)
+		if seen {
+			d.compare(now, "mac", d.Mac, drone.Mac)
+			d.compare(now, "ipv4", d.Ipv4, drone.Ipv4)
+			d.compare(now, "ipv6", d.Ipv6, drone.Ipv6)
+		}
+		d.Manufacturer, d.Model, d.Firmware = drone.Manufacturer, drone.Model, drone.Firmware
+		d.Mac, d.Ipv4, d.Ipv6 = drone.Mac, drone.Ipv4, drone.Ipv6
+		d.LastSeen = now
+		if len(d.Changes) != before {
+			d.Changed = true
+			if len(d.Changes) > maxChanges {
+				d.Changes = slices.Clone(d.Changes[len(d.Changes)-maxChanges:])
+			}
+			changed = append(changed, d.copy())
+		}
+	}
+	for serial, d := range r.devices {
+		if now.Sub(d.LastSeen) > r.retention {
+			delete(r.devices, serial)
+		}
+	}
+	return changed
+}
+
+func (d *Device) compare(now time.Time, field, previous, current string) {
+	if previous != current {
+		d.Changes = append(d.Changes, Change{Time: now, Field: field, Previous: previous, Current: current})
+	}
+}
+
+func (d *Device) copy() Device {
+	c := *d
+	c.Changes = slices.Clone(d.Changes)
+	return c
+}
+
+// List returns every device ordered by serial
+func (r *Registry) List() []Device {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	devices := make([]Device, 0, len(r.devices))
+	for _, d := range r.devices {
+		devices = append(devices, d.copy())
+	}
+	slices.SortFunc(devices, func(a, b Device) int {
+		return cmp.Compare(a.Serial, b.Serial)
+	})
+	return devices
+}
@@ -0,0 +1,38 @@
+package devices
+
+import (
+	"reaktor-birdnest/internal/models"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New(time.Hour)
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	drone := models.Drone{SerialNumber: "SN-1", Manufacturer: "DJI", Model: "Mavic", Firmware: "1.0", Mac: "aa:aa", Ipv4: "10.0.0.1", Ipv6: "::1"}
+	other := models.Drone{SerialNumber: "SN-2", Mac: "bb:bb"}
+
+	if changed := r.Observe([]models.Drone{drone, other}, start); len(changed) != 0 {
+		t.Errorf("Expected no changes on the first sighting, but was %v.", changed)
+	}
+	drone.Firmware = "1.1"
+	if changed := r.Observe([]models.Drone{drone}, start.Add(time.Minute)); len(changed) != 0 {
+		t.Errorf("Expected a firmware update not to be flagged, but was %v.", changed)
+	}
+
+	drone.Mac, drone.Ipv4 = "cc:cc", "10.0.0.2"
+	changed := r.Observe([]models.Drone{drone}, start.Add(2*time.Minute))
+	if len(changed) != 1 || !changed[0].Changed || len(changed[0].Changes) != 2 || changed[0].Mac != "cc:cc" {
+		t.Fatalf("Expected SN-1 to be flagged with 2 changes, but was %v.", changed)
+	}
+	if c := changed[0].Changes[0]; c.Field != "mac" || c.Previous != "aa:aa" || c.Current != "cc:cc" {
+		t.Errorf("Expected the MAC change, but was %v.", c)
+	}
+
+	// SN-2 has not been seen for over an hour
+	r.Observe([]models.Drone{drone}, start.Add(90*time.Minute))
+	devices := r.List()
+	if len(devices) != 1 || devices[0].Firmware != "1.1" || !devices[0].FirstSeen.Equal(start) {
+		t.Errorf("Expected only SN-1 with its latest firmware, but was %v.", devices)
+	}
+}
@@ -0,0 +1,59 @@
+package geo
+
+import "math"
+
+// earthRadius is the WGS84 equatorial radius in meters
+const earthRadius = 6378137
+
+// Reference places sensor coordinates on the globe. Over the few hundred
+// meters of a sensor area the earth is flat enough to offset the origin by
+// the meters east and north.
+type Reference struct {
+	// Lat and Lon of the sensor's x = 0, y = 0 in degrees
+	Lat float64 `yaml:"lat"`
+	Lon float64 `yaml:"lon"`
+	// Rotation of the sensor's y axis in degrees clockwise from north
+	Rotation float64 `yaml:"rotation"`
+	// Scale is the meters on the ground per sensor meter, 1 when left out
+	Scale float64 `yaml:"scale"`
+}
+
+// ToWGS84 returns the latitude and longitude in degrees of the sensor
+// coordinates x and y in meters
+func (r Reference) ToWGS84(x, y float64) (lat, lon float64) {
+	sin, cos := math.Sincos(r.Rotation * math.Pi / 180)
+	east := r.scale() * (x*cos + y*sin)
+	north := r.scale() * (y*cos - x*sin)
+	lat = r.Lat + north/earthRadius*180/math.Pi
+	lon = r.Lon + east/(earthRadius*math.Cos(r.Lat*math.Pi/180))*180/math.Pi
+	return lat, lon
+}
+
+// FromWGS84 returns the sensor coordinates in meters of the latitude and
+// longitude in degrees
+func (r Reference) FromWGS84(lat, lon float64) (x, y float64) {
+	north := (lat - r.Lat) * math.Pi / 180 * earthRadius
+	east := (lon - r.Lon) * math.Pi / 180 * earthRadius * math.Cos(r.Lat*math.Pi/180)
+	sin, cos := math.Sincos(r.Rotation * math.Pi / 180)
+	x = (east*cos - north*sin) / r.scale()
+	y = (east*sin + north*cos) / r.scale()
+	return x, y
+}
+
+// Locate returns the latitude and longitude of the sensor coordinates x and
+// y in meters, both nil when r is, so that positions of sites without a
+// reference leave them out
+func (r *Reference) Locate(x, y float64) (lat, lon *float64) {
+	if r == nil {
+		return nil, nil
+	}
+	la, lo := r.ToWGS84(x, y)
+	return &la, &lo
+}
+
+func (r Reference) scale() float64 {
+	if r.Scale == 0 {
+		return 1
+	}
+	return r.Scale
+}
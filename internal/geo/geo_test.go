@@ -0,0 +1,37 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToWGS84(t *testing.T) {
+	reference := Reference{Lat: 60, Lon: 25}
+
+	// A degree of latitude is about 111.3 km, of longitude half that at 60°
+	lat, lon := reference.ToWGS84(500, 1000)
+	if math.Abs(lat-60.008983) > 1e-6 || math.Abs(lon-25.008983) > 1e-6 {
+		t.Errorf("Expected 60.008983, 25.008983, but was %v, %v.", lat, lon)
+	}
+
+	// With the y axis pointing east, x points south
+	reference.Rotation, reference.Scale = 90, 2
+	lat, lon = reference.ToWGS84(500, 250)
+	if math.Abs(lat-59.991017) > 1e-6 || math.Abs(lon-25.008983) > 1e-6 {
+		t.Errorf("Expected 59.991017, 25.008983, but was %v, %v.", lat, lon)
+	}
+
+	if lat, lon := (*Reference)(nil).Locate(500, 250); lat != nil || lon != nil {
+		t.Errorf("Expected no location without a reference, but was %v, %v.", lat, lon)
+	}
+}
+
+func TestFromWGS84(t *testing.T) {
+	reference := Reference{Lat: 60.1699, Lon: 24.9384, Rotation: -30, Scale: 1.02}
+	for _, p := range [][2]float64{{0, 0}, {250, 250}, {500, -120}} {
+		x, y := reference.FromWGS84(reference.ToWGS84(p[0], p[1]))
+		if math.Abs(x-p[0]) > 1e-6 || math.Abs(y-p[1]) > 1e-6 {
+			t.Errorf("Expected %v back, but was %v, %v.", p, x, y)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package heatmap
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"sync"
+	"time"
+)
+
+// AreaSize is the side of the sensor area in meters
+const AreaSize = 500
+
+// Heatmap counts the drones of every report in the cells of a grid over the
+// sensor area, in time buckets so that a window of the latest counts can be
+// summed up
+type Heatmap struct {
+	cellSize  float64
+	cells     int
+	bucket    time.Duration
+	retention time.Duration
+
+	mut sync.RWMutex
+	// buckets oldest first
+	buckets []*bucket
+}
+
+type bucket struct {
+	start time.Time
+	// drones and incursions by row and column
+	drones, incursions []uint32
+}
+
+// Grid is the number of sightings in each cell over a window, rows start
+// from the sensor's y = 0 and columns from x = 0
+type Grid struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	CellSize float64   `json:"cellSize"`
+	// Drones counts every drone, Incursions only those inside a zone
+	Drones     [][]uint32 `json:"drones"`
+	Incursions [][]uint32 `json:"incursions"`
+}
+
+func New(cfg config.Heatmap) *Heatmap {
+	return &Heatmap{
+		cellSize:  cfg.CellSize,
+		cells:     int(math.Ceil(AreaSize / cfg.CellSize)),
+		bucket:    cfg.Bucket,
+		retention: cfg.Retention,
+	}
+}
+
+// Add counts the drones of a report, drones outside the sensor area are left
+// out
+func (h *Heatmap) Add(zones []models.Zone, drones []models.Drone, now time.Time) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	start := now.Truncate(h.bucket)
+	if len(h.buckets) == 0 || h.buckets[len(h.buckets)-1].start.Before(start) {
+		h.buckets = append(h.buckets, &bucket{
+			start:      start,
+			drones:     make([]uint32, h.cells*h.cells),
+			incursions: make([]uint32, h.cells*h.cells),
+		})
+	}
+	// The current bucket never expires
+	expired := 0
+	for expired < len(h.buckets)-1 && !h.buckets[expired].start.Add(h.bucket).After(now.Add(-h.retention)) {
+		expired++
+	}
+	h.buckets = h.buckets[expired:]
+
+	b := h.buckets[len(h.buckets)-1]
+	for _, drone := range drones {
+		i, ok := h.cell(drone.PositionX/1000, drone.PositionY/1000)
+		if !ok {
+			continue
+		}
+		b.drones[i]++
+		for _, zone := range zones {
+			if zone.BoundaryDistance(drone) <= 0 {
+				b.incursions[i]++
+				break
+			}
+		}
+	}
+}
+
+func (h *Heatmap) cell(x, y float64) (int, bool) {
+	if x < 0 || y < 0 || x >= AreaSize || y >= AreaSize {
+		return 0, false
+	}
+	row, column := int(y/h.cellSize), int(x/h.cellSize)
+	return row*h.cells + column, true
+}
+
+// Grid sums up the counts of the buckets that started within the window
+// ending now
+func (h *Heatmap) Grid(window time.Duration, now time.Time) Grid {
+	h.mut.RLock()
+	defer h.mut.RUnlock()
+
+	from := now.Add(-window).Truncate(h.bucket)
+	g := Grid{From: from, To: now, CellSize: h.cellSize, Drones: h.rows(), Incursions: h.rows()}
+	for _, b := range h.buckets {
+		if b.start.Before(from) || b.start.After(now) {
+			continue
+		}
+		for i := range b.drones {
+			g.Drones[i/h.cells][i%h.cells] += b.drones[i]
+			g.Incursions[i/h.cells][i%h.cells] += b.incursions[i]
+		}
+	}
+	return g
+}
+
+func (h *Heatmap) rows() [][]uint32 {
+	rows := make([][]uint32, h.cells)
+	for i := range rows {
+		rows[i] = make([]uint32, h.cells)
+	}
+	return rows
+}
+
+// Image draws the counts with a pixel per cell, north up. Empty cells are
+// transparent and the others go from translucent blue to opaque red on a
+// logarithmic scale, so that a few busy cells do not hide the rest.
+func Image(counts [][]uint32) *image.NRGBA {
+	cells := len(counts)
+	img := image.NewNRGBA(image.Rect(0, 0, cells, cells))
+	peak := uint32(0)
+	for _, row := range counts {
+		for _, c := range row {
+			peak = max(peak, c)
+		}
+	}
+	if peak == 0 {
+		return img
+	}
+	for y, row := range counts {
+		for x, c := range row {
+			if c == 0 {
+				continue
+			}
+			t := math.Log1p(float64(c)) / math.Log1p(float64(peak))
+			img.SetNRGBA(x, cells-1-y, color.NRGBA{
+				R: uint8(255 * t),
+				G: uint8(64 * (1 - t)),
+				B: uint8(255 * (1 - t)),
+				A: uint8(96 + 159*t),
+			})
+		}
+	}
+	return img
+}
@@ -0,0 +1,49 @@
+package heatmap
+
+import (
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"testing"
+	"time"
+)
+
+func TestHeatmap(t *testing.T) {
+	h := New(config.Heatmap{CellSize: 100, Bucket: time.Minute, Retention: 10 * time.Minute})
+	zones := []models.Zone{{OriginX: 250000, OriginY: 250000, Radius: 100}}
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	drones := []models.Drone{
+		{SerialNumber: "SN-1", PositionX: 250000, PositionY: 250000},
+		{SerialNumber: "SN-2", PositionX: 10000, PositionY: 420000},
+		{SerialNumber: "SN-3", PositionX: 500000, PositionY: 10000},
+	}
+
+	h.Add(zones, drones, start)
+	h.Add(zones, drones[:1], start.Add(5*time.Minute))
+	g := h.Grid(time.Hour, start.Add(5*time.Minute))
+	if len(g.Drones) != 5 || len(g.Drones[0]) != 5 {
+		t.Fatalf("Expected a 5 × 5 grid, but was %v.", g.Drones)
+	}
+	if g.Drones[2][2] != 2 || g.Incursions[2][2] != 2 || g.Drones[4][0] != 1 || g.Incursions[4][0] != 0 {
+		t.Errorf("Expected the drones in their cells, but was %v and %v.", g.Drones, g.Incursions)
+	}
+
+	// The first bucket is outside the window, then expires
+	if g := h.Grid(2*time.Minute, start.Add(5*time.Minute)); g.Drones[2][2] != 1 || g.Drones[4][0] != 0 {
+		t.Errorf("Expected only the latest bucket in the window, but was %v.", g.Drones)
+	}
+	h.Add(zones, nil, start.Add(11*time.Minute))
+	if g := h.Grid(time.Hour, start.Add(11*time.Minute)); g.Drones[4][0] != 0 || g.Drones[2][2] != 1 {
+		t.Errorf("Expected the first bucket to have expired, but was %v.", g.Drones)
+	}
+
+	// A clock stepping back counts into the latest bucket
+	h.Add(zones, drones[:1], start.Add(-time.Hour))
+	if g := h.Grid(time.Hour, start.Add(11*time.Minute)); g.Drones[2][2] != 2 {
+		t.Errorf("Expected the drone of the earlier report in the latest bucket, but was %v.", g.Drones)
+	}
+
+	img := Image(g.Drones)
+	if img.Bounds().Dx() != 5 || img.NRGBAAt(0, 0).A == 0 || img.NRGBAAt(0, 4).A != 0 {
+		t.Errorf("Expected the north-west cell at the top left of the image.")
+	}
+}
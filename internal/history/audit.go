@@ -0,0 +1,55 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// AuditEntry records an erasure without the personal data that was erased
+type AuditEntry struct {
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	// Remote is the address the request came from
+	Remote string `json:"remote,omitempty"`
+	// Subject is the kind of identifier erased, "pilotId" or "email"
+	Subject string `json:"subject"`
+	// SubjectHash is the SHA-256 of the identifier, it proves what was erased
+	// to someone who already knows the identifier
+	SubjectHash string `json:"subjectHash"`
+	Reason      string `json:"reason,omitempty"`
+	// Erased counts the removed items by where they were kept
+	Erased map[string]int `json:"erased"`
+	// Error is set when the erasure was incomplete
+	Error string `json:"error,omitempty"`
+}
+
+// HashSubject returns the SubjectHash of an identifier
+func HashSubject(identifier string) string {
+	hash := sha256.Sum256([]byte(identifier))
+	return hex.EncodeToString(hash[:])
+}
+
+// AppendAudit appends the entry to the JSON lines file at path and syncs it
+func AppendAudit(path string, entry AuditEntry) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,311 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reaktor-birdnest/internal/models"
+	"reaktor-birdnest/internal/notify"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Record is a past or ongoing violation of a pilot at a site
+type Record struct {
+	ID              string       `json:"id"`
+	Site            string       `json:"site"`
+	Pilot           models.Pilot `json:"pilot"`
+	ClosestDistance float64      `json:"closestDistance"`
+	Start           time.Time    `json:"start"`
+	// End is zero while the violation is ongoing
+	End time.Time `json:"end"`
+	// Episodes are the pilot's drone's stays inside the zones during the
+	// violation, the last one is open while the drone is inside
+	Episodes []models.Episode `json:"episodes,omitempty"`
+	// Anonymised records no longer identify the pilot
+	Anonymised bool `json:"anonymised,omitempty"`
+}
+
+// Archive keeps every violation. With a path the records are appended to a
+// JSON lines file as they change, the file is compacted when records are
+// erased or anonymised so that no earlier version of them is left.
+type Archive struct {
+	path string
+
+	mut     sync.RWMutex
+	records []*Record
+	// ongoing records by site and pilot id
+	ongoing map[string]*Record
+	file    *os.File
+}
+
+// Open loads the archive from path, or keeps it in memory only when path is
+// empty
+func Open(path string) (*Archive, error) {
+	a := &Archive{path: path, ongoing: make(map[string]*Record)}
+	if len(path) == 0 {
+		return a, nil
+	}
+
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	if err := a.compact(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Archive) load() error {
+	f, err := os.Open(a.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The latest line of a record wins
+	byID := make(map[string]*Record)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			return err
+		}
+		if existing, ok := byID[r.ID]; ok {
+			*existing = *r
+			continue
+		}
+		byID[r.ID] = r
+		a.records = append(a.records, r)
+	}
+	for _, r := range a.records {
+		if r.End.IsZero() {
+			a.ongoing[ongoingKey(r.Site, r.Pilot.PilotID)] = r
+		}
+	}
+	return scanner.Err()
+}
+
+func ongoingKey(site, pilotID string) string {
+	return site + "/" + pilotID
+}
+
+// Record updates the archive with a violation event
+func (a *Archive) Record(event notify.Event) error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	key := ongoingKey(event.Site, event.Pilot.PilotID)
+	r, ok := a.ongoing[key]
+	switch event.Type {
+	case notify.ViolationStarted:
+		if ok {
+			// Missed the end of the previous violation
+			r.End = event.Time
+			if err := a.append(r); err != nil {
+				return err
+			}
+		}
+		r = &Record{
+			ID:              event.ID,
+			Site:            event.Site,
+			Pilot:           event.Pilot,
+			ClosestDistance: event.ClosestDistance,
+			Start:           event.Time,
+		}
+		a.records = append(a.records, r)
+		a.ongoing[key] = r
+	case notify.ViolationUpdated:
+		if !ok {
+			return nil
+		}
+		r.ClosestDistance = min(r.ClosestDistance, event.ClosestDistance)
+	case notify.ViolationExpired:
+		if !ok {
+			return nil
+		}
+		r.End = event.Time
+		delete(a.ongoing, key)
+	case notify.ZoneEntered, notify.ZoneExited:
+		// Episodes of drones whose pilot is unknown are not archived
+		if !ok || event.Episode == nil {
+			return nil
+		}
+		i := slices.IndexFunc(r.Episodes, func(e models.Episode) bool { return e.ID == event.Episode.ID })
+		if i < 0 {
+			r.Episodes = append(r.Episodes, *event.Episode)
+		} else {
+			r.Episodes[i] = *event.Episode
+		}
+	default:
+		return nil
+	}
+	return a.append(r)
+}
+
+// Records returns a copy of every record, oldest first
+func (a *Archive) Records() []Record {
+	a.mut.RLock()
+	defer a.mut.RUnlock()
+
+	records := make([]Record, len(a.records))
+	for i, r := range a.records {
+		records[i] = *r
+		records[i].Episodes = slices.Clone(r.Episodes)
+	}
+	return records
+}
+
+// Page returns a copy of at most limit records from offset, oldest first, so
+// that the archive can be read without holding on to its lock
+func (a *Archive) Page(offset, limit int) []Record {
+	a.mut.RLock()
+	defer a.mut.RUnlock()
+
+	if offset >= len(a.records) {
+		return nil
+	}
+	page := a.records[offset:min(offset+limit, len(a.records))]
+	records := make([]Record, len(page))
+	for i, r := range page {
+		records[i] = *r
+		records[i].Episodes = slices.Clone(r.Episodes)
+	}
+	return records
+}
+
+// Ongoing returns the ongoing violation of a pilot at a site
+func (a *Archive) Ongoing(site, pilotID string) (Record, bool) {
+	a.mut.RLock()
+	defer a.mut.RUnlock()
+
+	r, ok := a.ongoing[ongoingKey(site, pilotID)]
+	if !ok {
+		return Record{}, false
+	}
+	record := *r
+	record.Episodes = slices.Clone(r.Episodes)
+	return record, true
+}
+
+// Erase removes every record of the matching pilots and returns how many
+// there were
+func (a *Archive) Erase(match func(models.Pilot) bool) (int, error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	kept := a.records[:0]
+	erased := 0
+	for _, r := range a.records {
+		if !r.Anonymised && match(r.Pilot) {
+			delete(a.ongoing, ongoingKey(r.Site, r.Pilot.PilotID))
+			erased++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	a.records = kept
+	if erased == 0 {
+		return 0, nil
+	}
+	return erased, a.compact()
+}
+
+// Anonymise removes the pilot from the records that ended before the given
+// time and returns how many were anonymised
+func (a *Archive) Anonymise(before time.Time) (int, error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	anonymised := 0
+	for _, r := range a.records {
+		if r.Anonymised || r.End.IsZero() || !r.End.Before(before) {
+			continue
+		}
+		r.Pilot = models.Pilot{}
+		// A serial number identifies the drone and through it the pilot
+		for i := range r.Episodes {
+			r.Episodes[i].Serial = ""
+		}
+		r.Anonymised = true
+		anonymised++
+	}
+	if anonymised == 0 {
+		return 0, nil
+	}
+	return anonymised, a.compact()
+}
+
+func (a *Archive) Close() error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// append writes the current state of the record, the caller must hold a.mut
+func (a *Archive) append(r *Record) error {
+	if a.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = a.file.Write(append(data, '\n'))
+	return err
+}
+
+// compact rewrites the file with the latest version of every record and
+// reopens it for appending. The caller must hold a.mut.
+func (a *Archive) compact() error {
+	if len(a.path) == 0 {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	for _, r := range a.records {
+		if err := encoder.Encode(r); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return err
+	}
+	a.file, err = os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	return err
+}
@@ -0,0 +1,155 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reaktor-birdnest/internal/models"
+	"reaktor-birdnest/internal/notify"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveRecordsViolations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	a, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	a.Record(event("1", notify.ViolationStarted, "P-1", 90, start))
+	a.Record(event("2", notify.ViolationUpdated, "P-1", 40, start.Add(time.Minute)))
+	a.Record(event("3", notify.ViolationExpired, "P-1", 40, start.Add(10*time.Minute)))
+	a.Record(event("4", notify.ViolationStarted, "P-2", 80, start.Add(time.Minute)))
+	a.Close()
+
+	// Reopening replays the appended lines
+	a, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	records := a.Records()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, but was %v.", records)
+	}
+	if r := records[0]; r.Pilot.PilotID != "P-1" || r.ClosestDistance != 40 || !r.End.Equal(start.Add(10*time.Minute)) {
+		t.Errorf("Expected P-1 to have ended at 40 m, but was %v.", r)
+	}
+	if r := records[1]; r.Pilot.PilotID != "P-2" || !r.End.IsZero() {
+		t.Errorf("Expected P-2 to be ongoing, but was %v.", r)
+	}
+
+	// The ongoing violation continues after reopening
+	a.Record(event("5", notify.ViolationExpired, "P-2", 80, start.Add(20*time.Minute)))
+	if r := a.Records()[1]; r.End.IsZero() {
+		t.Errorf("Expected P-2 to have ended, but was %v.", r)
+	}
+}
+
+func TestArchiveErase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	a, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	now := time.Now()
+
+	a.Record(event("1", notify.ViolationStarted, "P-1", 90, now))
+	a.Record(event("2", notify.ViolationExpired, "P-1", 90, now))
+	a.Record(event("3", notify.ViolationStarted, "P-1", 70, now))
+	a.Record(event("4", notify.ViolationStarted, "P-2", 80, now))
+
+	erased, err := a.Erase(func(p models.Pilot) bool { return p.Email == "p-1@example.com" })
+	if err != nil || erased != 2 {
+		t.Errorf("Expected 2 records to be erased, but was %d with %v.", erased, err)
+	}
+	if records := a.Records(); len(records) != 1 || records[0].Pilot.PilotID != "P-2" {
+		t.Errorf("Expected only P-2 to be left, but was %v.", records)
+	}
+
+	// No earlier line of the erased records is left in the file
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "P-1") {
+		t.Errorf("Expected P-1 to be gone from the file, but was %s.", data)
+	}
+}
+
+func TestArchiveAnonymise(t *testing.T) {
+	a, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	a.Record(event("1", notify.ViolationStarted, "P-1", 90, now.Add(-48*time.Hour)))
+	a.Record(event("2", notify.ViolationExpired, "P-1", 90, now.Add(-47*time.Hour)))
+	a.Record(event("3", notify.ViolationStarted, "P-2", 80, now.Add(-48*time.Hour)))
+
+	anonymised, err := a.Anonymise(now.Add(-24 * time.Hour))
+	if err != nil || anonymised != 1 {
+		t.Errorf("Expected 1 record to be anonymised, but was %d with %v.", anonymised, err)
+	}
+	records := a.Records()
+	if r := records[0]; !r.Anonymised || len(r.Pilot.PilotID) != 0 || r.ClosestDistance != 90 {
+		t.Errorf("Expected the ended record to keep only the violation, but was %v.", r)
+	}
+	// Ongoing violations are kept until they end
+	if r := records[1]; r.Anonymised {
+		t.Errorf("Expected the ongoing record to be kept, but was %v.", r)
+	}
+}
+
+func TestArchiveEpisodes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	a, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	episode := func(id, eventType, pilotID string, exited time.Time) notify.Event {
+		e := event(id, eventType, pilotID, 50, start)
+		e.Episode = &models.Episode{ID: "E-" + pilotID, Serial: "SN-" + pilotID, Entered: start, Exited: exited}
+		return e
+	}
+
+	a.Record(event("1", notify.ViolationStarted, "P-1", 90, start))
+	a.Record(episode("2", notify.ZoneEntered, "P-1", time.Time{}))
+	a.Record(episode("3", notify.ZoneExited, "P-1", start.Add(time.Minute)))
+	// Without a violation the pilot is unknown
+	a.Record(episode("4", notify.ZoneEntered, "P-2", time.Time{}))
+	a.Close()
+
+	a, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	r, ok := a.Ongoing("north", "P-1")
+	if !ok || len(r.Episodes) != 1 || !r.Episodes[0].Exited.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the closed episode in the ongoing record, but was %v.", r)
+	}
+	if len(a.Records()) != 1 {
+		t.Errorf("Expected no record for the unknown pilot, but was %v.", a.Records())
+	}
+
+	a.Record(event("5", notify.ViolationExpired, "P-1", 50, start.Add(time.Hour)))
+	a.Anonymise(start.Add(2 * time.Hour))
+	if r := a.Records()[0]; len(r.Episodes) != 1 || len(r.Episodes[0].Serial) != 0 {
+		t.Errorf("Expected the anonymised episode to lose the serial, but was %v.", r.Episodes)
+	}
+}
+
+func event(id, eventType, pilotID string, distance float64, at time.Time) notify.Event {
+	return notify.Event{
+		ID:              id,
+		Type:            eventType,
+		Site:            "north",
+		Time:            at,
+		Pilot:           models.Pilot{PilotID: pilotID, Email: strings.ToLower(pilotID) + "@example.com"},
+		ClosestDistance: distance,
+	}
+}
@@ -1,16 +1,32 @@
 package interfaces
 
-import "reaktor-birdnest/internal/models"
+import (
+	"context"
+	"log/slog"
+	"reaktor-birdnest/internal/models"
+	"time"
+)
 
 type Birdnest interface {
-	GetReport() (models.Report, error)
-	GetDronePilot(droneSerialNumber string) (models.Pilot, error)
+	// GetReport logs the drones it drops to logger
+	GetReport(ctx context.Context, logger *slog.Logger) (models.Report, error)
+	GetDronePilot(ctx context.Context, droneSerialNumber string) (models.Pilot, error)
 }
 
 type Violations interface {
 	Get(id string) (models.Violation, bool)
 	Upsert(id string, data models.Violation)
+	// DeleteFunc removes the matching violations and returns their count
+	DeleteFunc(match func(models.Violation) bool) int
 	Destroy()
+	// AsSlice returns the violations newest first, leaving out those that
+	// fail to decode
 	AsSlice() []models.Violation
+	// Values returns the same violations, and those that failed to decode as
+	// an error
+	Values() ([]models.Violation, error)
+	// IDs returns the ids of the violations without reading them
+	IDs() []string
 	HasChanges() bool
+	SetTTL(ttl time.Duration)
 }
@@ -1,57 +1,269 @@
 package birdnest
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"expvar"
+	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"io"
+	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
 	"reaktor-birdnest/internal/models"
+	"strconv"
+	"strings"
 )
 
+const DefaultUpstream = "https://assignments.reaktor.com/birdnest"
+
+var tracer = otel.Tracer("reaktor-birdnest/internal/models/birdnest")
+
+// Rejected counts the records dropped by validation or decoding, keyed by
+// reason
+var Rejected = expvar.NewMap("birdnest_rejected_records")
+
+// StatusError is returned when the upstream responds with a non-2xx status
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s responded with status %d", e.URL, e.StatusCode)
+}
+
+// ContentTypeError is returned when the upstream responds with an
+// unexpected media type
+type ContentTypeError struct {
+	URL         string
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("%s responded with unexpected content type %q", e.URL, e.ContentType)
+}
+
 type Birdnest struct {
+	upstream string
+	client   *http.Client
+	// strict rejects the whole report when any of its drones is invalid
+	// instead of dropping just those drones
+	strict bool
 }
 
-func (b Birdnest) GetReport() (models.Report, error) {
-	resp, err := http.Get("https://assignments.reaktor.com/birdnest/drones")
+func New(upstream string, strict bool) Birdnest {
+	if len(upstream) == 0 {
+		upstream = DefaultUpstream
+	}
+	return Birdnest{
+		upstream: upstream,
+		// Propagates trace headers to the upstream
+		client: &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		strict: strict,
+	}
+}
+
+func (b Birdnest) GetReport(ctx context.Context, logger *slog.Logger) (report models.Report, err error) {
+	ctx, span := tracer.Start(ctx, "birdnest.GetReport")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(attribute.Int("birdnest.drones", len(report.Capture.Drone)))
+		span.End()
+	}()
+
+	reportUrl, err := url.JoinPath(b.upstream, "drones")
 	if err != nil {
 		return models.Report{}, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := b.get(ctx, reportUrl, "application/xml", "text/xml")
 	if err != nil {
 		return models.Report{}, err
 	}
+	defer body.Close()
 
-	var report models.Report
-	err = xml.Unmarshal(body, &report)
+	report, err = b.decodeReport(body, logger)
 	if err != nil {
+		reject(err)
 		return models.Report{}, err
 	}
 	return report, nil
 }
 
-func (b Birdnest) GetDronePilot(droneSerialNumber string) (models.Pilot, error) {
-	droneUrl, err := url.JoinPath("https://assignments.reaktor.com/birdnest/pilots", droneSerialNumber)
-	if err != nil {
-		return models.Pilot{}, err
+// decodeReport streams the report so that drones are validated one at a
+// time instead of buffering the whole document
+func (b Birdnest) decodeReport(r io.Reader, logger *slog.Logger) (models.Report, error) {
+	var report models.Report
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return models.Report{}, &models.ValidationError{Record: "report", Field: "xml", Reason: models.ReasonMalformed, Err: err}
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "report":
+			report.XMLName = start.Name
+		case "deviceInformation":
+			if err := decoder.DecodeElement(&report.DeviceInformation, &start); err != nil {
+				return models.Report{}, &models.ValidationError{Record: "report", Field: "deviceInformation", Reason: models.ReasonMalformed, Err: err}
+			}
+		case "capture":
+			for _, attr := range start.Attr {
+				if attr.Name.Local != "snapshotTimestamp" {
+					continue
+				}
+				if err := report.Capture.SnapshotTimestamp.UnmarshalText([]byte(attr.Value)); err != nil {
+					return models.Report{}, &models.ValidationError{Record: "report", Field: "capture.snapshotTimestamp", Reason: models.ReasonMalformed}
+				}
+			}
+		case "drone":
+			var raw xmlDrone
+			if err := decoder.DecodeElement(&raw, &start); err != nil {
+				return models.Report{}, &models.ValidationError{Record: "drone", Field: "xml", Reason: models.ReasonMalformed, Err: err}
+			}
+			drone, err := raw.drone()
+			if err == nil {
+				err = drone.Validate()
+			}
+			if err != nil {
+				if b.strict {
+					return models.Report{}, err
+				}
+				reject(err)
+				logger.Warn("dropped invalid drone", "err", err)
+				continue
+			}
+			report.Capture.Drone = append(report.Capture.Drone, drone)
+		}
+	}
+
+	if err := report.Validate(); err != nil {
+		return models.Report{}, err
 	}
+	return report, nil
+}
 
-	resp, err := http.Get(droneUrl)
+func (b Birdnest) GetDronePilot(ctx context.Context, droneSerialNumber string) (pilot models.Pilot, err error) {
+	ctx, span := tracer.Start(ctx, "birdnest.GetDronePilot")
+	span.SetAttributes(attribute.String("drone.serial", droneSerialNumber))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(attribute.String("pilot.id", pilot.PilotID))
+		span.End()
+	}()
+
+	droneUrl, err := url.JoinPath(b.upstream, "pilots", droneSerialNumber)
 	if err != nil {
 		return models.Pilot{}, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := b.get(ctx, droneUrl, "application/json")
 	if err != nil {
 		return models.Pilot{}, err
 	}
+	defer body.Close()
 
-	var pilot models.Pilot
-	err = json.Unmarshal(body, &pilot)
-	if err != nil {
+	if err := json.NewDecoder(body).Decode(&pilot); err != nil {
+		err = &models.ValidationError{Record: "pilot", ID: droneSerialNumber, Field: "json", Reason: models.ReasonMalformed, Err: err}
+		reject(err)
 		return models.Pilot{}, err
 	}
 
+	if err := pilot.Validate(); err != nil {
+		reject(err)
+		return models.Pilot{}, err
+	}
 	return pilot, nil
 }
+
+// xmlDrone reads the numeric fields as text so that a drone with a malformed
+// number is dropped like any other invalid drone instead of failing the
+// whole report
+type xmlDrone struct {
+	models.Drone
+	PositionY string `xml:"positionY"`
+	PositionX string `xml:"positionX"`
+	Altitude  string `xml:"altitude"`
+}
+
+func (x xmlDrone) drone() (models.Drone, error) {
+	d := x.Drone
+	for _, f := range []struct {
+		name  string
+		text  string
+		value *float64
+	}{
+		{"positionY", x.PositionY, &d.PositionY},
+		{"positionX", x.PositionX, &d.PositionX},
+		{"altitude", x.Altitude, &d.Altitude},
+	} {
+		// Empty elements decode to zero like encoding/xml does
+		text := strings.TrimSpace(f.text)
+		if len(text) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return models.Drone{}, &models.ValidationError{Record: "drone", ID: d.SerialNumber, Field: f.name, Reason: models.ReasonMalformed, Err: err}
+		}
+		*f.value = v
+	}
+	return d, nil
+}
+
+// get returns the body of a successful response with one of the accepted
+// media types. The caller must close it.
+func (b Birdnest) get(ctx context.Context, u string, accepted ...string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &StatusError{URL: u, StatusCode: resp.StatusCode}
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, a := range accepted {
+		if mediaType == a {
+			return resp.Body, nil
+		}
+	}
+	resp.Body.Close()
+	return nil, &ContentTypeError{URL: u, ContentType: contentType}
+}
+
+func reject(err error) {
+	if ve, ok := models.AsValidationError(err); ok {
+		Rejected.Add(ve.Key(), 1)
+	}
+}
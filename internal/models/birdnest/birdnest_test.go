@@ -0,0 +1,159 @@
+package birdnest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reaktor-birdnest/internal/models"
+	"strings"
+	"testing"
+)
+
+const reportXML = `<?xml version="1.0" encoding="UTF-8"?>
+<report>
+  <deviceInformation deviceId="GUARDB1RD">
+    <listenRange>500000</listenRange>
+    <deviceStarted>2023-01-01T10:00:00.000Z</deviceStarted>
+    <uptimeSeconds>1200</uptimeSeconds>
+    <updateIntervalMs>2000</updateIntervalMs>
+  </deviceInformation>
+  <capture snapshotTimestamp="2023-01-01T10:20:00.000Z">
+    <drone>
+      <serialNumber>SN-GOOD</serialNumber>
+      <positionY>250000</positionY>
+      <positionX>250000</positionX>
+      <altitude>4000</altitude>
+    </drone>
+    <drone>
+      <serialNumber></serialNumber>
+      <positionY>250000</positionY>
+      <positionX>250000</positionX>
+      <altitude>4000</altitude>
+    </drone>
+    <drone>
+      <serialNumber>SN-NAN</serialNumber>
+      <positionY>NaN</positionY>
+      <positionX>250000</positionX>
+      <altitude>4000</altitude>
+    </drone>
+    <drone>
+      <serialNumber>SN-TEXT</serialNumber>
+      <positionY>250000</positionY>
+      <positionX>far</positionX>
+      <altitude>4000</altitude>
+    </drone>
+  </capture>
+</report>`
+
+func TestGetReportDropsInvalidDrones(t *testing.T) {
+	b := newTestBirdnest(t, "application/xml", http.StatusOK, reportXML, false)
+	before := rejectedCount("drone.positionY.not_finite")
+	beforeMalformed := rejectedCount("drone.positionX.malformed")
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil)).With("tick", "t-1")
+
+	report, err := b.GetReport(context.Background(), logger)
+	if err != nil {
+		t.Fatalf("Expected report to be decoded, but got %v.", err)
+	}
+
+	if len(report.Capture.Drone) != 1 || report.Capture.Drone[0].SerialNumber != "SN-GOOD" {
+		t.Errorf("Expected only SN-GOOD to be kept, but was %v.", report.Capture.Drone)
+	}
+	if report.DeviceInformation.UpdateIntervalMs != 2000 {
+		t.Errorf("Expected update interval to be 2000, but was %d.", report.DeviceInformation.UpdateIntervalMs)
+	}
+	if report.Capture.SnapshotTimestamp.IsZero() {
+		t.Errorf("Expected snapshot timestamp to be decoded.")
+	}
+	if after := rejectedCount("drone.positionY.not_finite"); after != before+1 {
+		t.Errorf("Expected rejection count to grow by one, but went from %d to %d.", before, after)
+	}
+	if after := rejectedCount("drone.positionX.malformed"); after != beforeMalformed+1 {
+		t.Errorf("Expected malformed count to grow by one, but went from %d to %d.", beforeMalformed, after)
+	}
+	if n := strings.Count(logs.String(), "tick=t-1"); n != 3 {
+		t.Errorf("Expected 3 dropped drones to be logged with the tick, but was %d in %q.", n, logs.String())
+	}
+}
+
+func TestGetReportStrict(t *testing.T) {
+	b := newTestBirdnest(t, "application/xml", http.StatusOK, reportXML, true)
+
+	_, err := b.GetReport(context.Background(), slog.Default())
+	ve, ok := models.AsValidationError(err)
+	if !ok {
+		t.Fatalf("Expected a validation error, but got %v.", err)
+	}
+	if ve.Field != "serialNumber" || ve.Reason != models.ReasonMissing {
+		t.Errorf("Expected missing serial number, but was %s.", ve.Key())
+	}
+}
+
+func TestGetReportChecksResponse(t *testing.T) {
+	b := newTestBirdnest(t, "application/xml", http.StatusBadGateway, "", false)
+	var statusErr *StatusError
+	if _, err := b.GetReport(context.Background(), slog.Default()); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected a status error, but got %v.", err)
+	}
+
+	b = newTestBirdnest(t, "text/html", http.StatusOK, "<html></html>", false)
+	var contentTypeErr *ContentTypeError
+	if _, err := b.GetReport(context.Background(), slog.Default()); !errors.As(err, &contentTypeErr) {
+		t.Errorf("Expected a content type error, but got %v.", err)
+	}
+}
+
+func TestGetDronePilotValidates(t *testing.T) {
+	b := newTestBirdnest(t, "application/json; charset=utf-8", http.StatusOK, `{"firstName":"Bob"}`, false)
+
+	_, err := b.GetDronePilot(context.Background(), "SN-GOOD")
+	if ve, ok := models.AsValidationError(err); !ok || ve.Field != "pilotId" {
+		t.Errorf("Expected missing pilot id, but got %v.", err)
+	}
+}
+
+func TestGetDronePilotCountsMalformed(t *testing.T) {
+	b := newTestBirdnest(t, "application/json", http.StatusOK, `{"pilotId":`, false)
+	before := rejectedCount("pilot.json.malformed")
+
+	_, err := b.GetDronePilot(context.Background(), "SN-GOOD")
+	if ve, ok := models.AsValidationError(err); !ok || ve.Reason != models.ReasonMalformed {
+		t.Errorf("Expected a malformed pilot, but got %v.", err)
+	}
+	if after := rejectedCount("pilot.json.malformed"); after != before+1 {
+		t.Errorf("Expected rejection count to grow by one, but went from %d to %d.", before, after)
+	}
+}
+
+func TestGetReportCountsMalformedXML(t *testing.T) {
+	b := newTestBirdnest(t, "application/xml", http.StatusOK, "<report><capture>", false)
+	before := rejectedCount("report.xml.malformed")
+
+	if _, err := b.GetReport(context.Background(), slog.Default()); err == nil {
+		t.Errorf("Expected truncated report to fail.")
+	}
+	if after := rejectedCount("report.xml.malformed"); after != before+1 {
+		t.Errorf("Expected rejection count to grow by one, but went from %d to %d.", before, after)
+	}
+}
+
+func newTestBirdnest(t *testing.T, contentType string, status int, body string, strict bool) Birdnest {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return New(server.URL, strict)
+}
+
+func rejectedCount(key string) int64 {
+	if v := Rejected.Get(key); v != nil {
+		return v.(interface{ Value() int64 }).Value()
+	}
+	return 0
+}
@@ -2,39 +2,46 @@ package models
 
 import (
 	"encoding/xml"
+	"math"
+	"strings"
 	"time"
 )
 
 type Report struct {
-	XMLName           xml.Name `xml:"report"`
-	Text              string   `xml:",chardata"`
-	DeviceInformation struct {
-		Text             string `xml:",chardata"`
-		DeviceId         string `xml:"deviceId,attr"`
-		ListenRange      string `xml:"listenRange"`
-		DeviceStarted    string `xml:"deviceStarted"`
-		UptimeSeconds    string `xml:"uptimeSeconds"`
-		UpdateIntervalMs string `xml:"updateIntervalMs"`
-	} `xml:"deviceInformation"`
-	Capture struct {
+	XMLName           xml.Name          `xml:"report"`
+	Text              string            `xml:",chardata"`
+	DeviceInformation DeviceInformation `xml:"deviceInformation"`
+	Capture           struct {
 		Text              string    `xml:",chardata"`
 		SnapshotTimestamp time.Time `xml:"snapshotTimestamp,attr"`
 		Drone             []Drone   `xml:"drone"`
 	} `xml:"capture"`
 }
 
+type DeviceInformation struct {
+	Text             string `xml:",chardata"`
+	DeviceId         string `xml:"deviceId,attr"`
+	ListenRange      string `xml:"listenRange"`
+	DeviceStarted    string `xml:"deviceStarted"`
+	UptimeSeconds    int64  `xml:"uptimeSeconds"`
+	UpdateIntervalMs int64  `xml:"updateIntervalMs"`
+}
+
 type Drone struct {
-	Text         string  `xml:",chardata"`
-	SerialNumber string  `xml:"serialNumber"`
-	Model        string  `xml:"model"`
-	Manufacturer string  `xml:"manufacturer"`
-	Mac          string  `xml:"mac"`
-	Ipv4         string  `xml:"ipv4"`
-	Ipv6         string  `xml:"ipv6"`
-	Firmware     string  `xml:"firmware"`
-	PositionY    float64 `xml:"positionY"`
-	PositionX    float64 `xml:"positionX"`
-	Altitude     float64 `xml:"altitude"`
+	Text         string  `xml:",chardata" json:"-"`
+	SerialNumber string  `xml:"serialNumber" json:"serialNumber"`
+	Model        string  `xml:"model" json:"model"`
+	Manufacturer string  `xml:"manufacturer" json:"manufacturer"`
+	Mac          string  `xml:"mac" json:"mac"`
+	Ipv4         string  `xml:"ipv4" json:"ipv4"`
+	Ipv6         string  `xml:"ipv6" json:"ipv6"`
+	Firmware     string  `xml:"firmware" json:"firmware"`
+	PositionY    float64 `xml:"positionY" json:"positionY"`
+	PositionX    float64 `xml:"positionX" json:"positionX"`
+	Altitude     float64 `xml:"altitude" json:"altitude"`
+	// Lat and Lon in degrees, filled in when the site has an origin
+	Lat *float64 `xml:"-" json:"lat,omitempty"`
+	Lon *float64 `xml:"-" json:"lon,omitempty"`
 }
 
 type Pilot struct {
@@ -47,6 +54,195 @@ type Pilot struct {
 }
 
 type Violation struct {
-	Pilot           Pilot
-	ClosestDistance float64
+	Pilot           Pilot   `json:"pilot"`
+	ClosestDistance float64 `json:"closestDistance"`
+}
+
+// Episode is a drone's single stay inside the zones of a site, from entering
+// them to leaving them. A drone re-entering after leaving starts a new one.
+type Episode struct {
+	ID     string `json:"id"`
+	Serial string `json:"serial"`
+	// Manufacturer, Model and Firmware of the drone, kept when the episode
+	// is anonymised
+	Manufacturer string    `json:"manufacturer"`
+	Model        string    `json:"model"`
+	Firmware     string    `json:"firmware"`
+	Entered      time.Time `json:"entered"`
+	// Exited is zero while the drone is inside
+	Exited time.Time `json:"exited"`
+	// Duration in seconds from entering to leaving, or until the latest
+	// report while inside
+	Duration float64 `json:"duration"`
+	// TimeInside is the seconds between the first and the last report that
+	// saw the drone inside
+	TimeInside float64 `json:"timeInside"`
+	// ClosestDistance to the origin of a zone in meters, seen at
+	// ClosestPoint
+	ClosestDistance float64 `json:"closestDistance"`
+	ClosestPoint    Point   `json:"closestPoint"`
+	// EntryPoint and ExitPoint are where the drone crossed the boundary when
+	// it was seen on both sides of it, otherwise where it was first and last
+	// seen inside. ExitPoint is nil while inside.
+	EntryPoint Point  `json:"entryPoint"`
+	ExitPoint  *Point `json:"exitPoint"`
+}
+
+// Point in sensor coordinates in meters, with the latitude and longitude in
+// degrees when the site has an origin
+type Point struct {
+	X   float64  `json:"x"`
+	Y   float64  `json:"y"`
+	Lat *float64 `json:"lat,omitempty"`
+	Lon *float64 `json:"lon,omitempty"`
+}
+
+// Zone is a circular no-fly zone. The origin is in sensor coordinates
+// (millimeters) and the radius in meters.
+type Zone struct {
+	OriginX float64 `json:"originX" yaml:"originX"`
+	OriginY float64 `json:"originY" yaml:"originY"`
+	Radius  float64 `json:"radius" yaml:"radius"`
+	// Lat and Lon of the origin in degrees, which may be given instead of
+	// OriginX and OriginY when the site has an origin. Filled in from them
+	// otherwise.
+	Lat *float64 `json:"lat,omitempty" yaml:"lat"`
+	Lon *float64 `json:"lon,omitempty" yaml:"lon"`
+}
+
+// Distance from the zone origin to the drone in meters
+func (z Zone) Distance(drone Drone) float64 {
+	// Convert millimeters to meters
+	return math.Hypot(z.OriginX-drone.PositionX, z.OriginY-drone.PositionY) / 1000
+}
+
+// BoundaryDistance from the drone to the edge of the zone in meters,
+// negative inside the zone
+func (z Zone) BoundaryDistance(drone Drone) float64 {
+	return z.Distance(drone) - z.Radius
+}
+
+// Incursion returns the seconds until a drone at x, y moving at vx, vy, in
+// meters and meters per second, enters the zone. It is false when the drone
+// is already inside or will not enter the zone on its current course.
+func (z Zone) Incursion(x, y, vx, vy float64) (float64, bool) {
+	// Solve |p + v t - origin| = radius for the first t
+	dx, dy := x-z.OriginX/1000, y-z.OriginY/1000
+	a := vx*vx + vy*vy
+	b := 2 * (dx*vx + dy*vy)
+	c := dx*dx + dy*dy - z.Radius*z.Radius
+	if c <= 0 || a == 0 {
+		return 0, false
+	}
+	discriminant := b*b - 4*a*c
+	if discriminant < 0 {
+		return 0, false
+	}
+	t := (-b - math.Sqrt(discriminant)) / (2 * a)
+	if t < 0 {
+		return 0, false
+	}
+	return t, true
+}
+
+// Inside returns the distance to the origin of the nearest zone the drone is
+// inside of, false when it is outside every zone
+func Inside(zones []Zone, drone Drone) (float64, bool) {
+	closest, inside := 0.0, false
+	for _, zone := range zones {
+		if zone.BoundaryDistance(drone) > 0 {
+			continue
+		}
+		if distance := zone.Distance(drone); !inside || distance < closest {
+			closest, inside = distance, true
+		}
+	}
+	return closest, inside
+}
+
+// BoundaryDistance returns the distance to the edge of the nearest zone in
+// meters, negative inside a zone
+func BoundaryDistance(zones []Zone, drone Drone) float64 {
+	closest := math.Inf(1)
+	for _, zone := range zones {
+		closest = min(closest, zone.BoundaryDistance(drone))
+	}
+	return closest
+}
+
+// OriginDistance returns the distance to the origin of the nearest zone in
+// meters
+func OriginDistance(zones []Zone, drone Drone) float64 {
+	closest := math.Inf(1)
+	for _, zone := range zones {
+		closest = min(closest, zone.Distance(drone))
+	}
+	return closest
+}
+
+// Incursion returns the seconds until a drone at x, y moving at vx, vy, in
+// meters and meters per second, first enters one of the zones, if it does
+// within horizon seconds
+func Incursion(zones []Zone, x, y, vx, vy, horizon float64) (float64, bool) {
+	soonest, found := 0.0, false
+	for _, zone := range zones {
+		t, ok := zone.Incursion(x, y, vx, vy)
+		if ok && t <= horizon && (!found || t < soonest) {
+			soonest, found = t, true
+		}
+	}
+	return soonest, found
+}
+
+// Sighting is where a drone was seen in meters and when
+type Sighting struct {
+	X, Y float64
+	Time time.Time
+}
+
+// SightingOf returns where the drone of a report taken at the time was
+func SightingOf(drone Drone, at time.Time) Sighting {
+	// Convert millimeters to meters
+	return Sighting{X: drone.PositionX / 1000, Y: drone.PositionY / 1000, Time: at}
+}
+
+// Velocity returns the meters per second of a drone seen at previous and then
+// at s, false unless time passed in between
+func (s Sighting) Velocity(previous Sighting) (vx, vy float64, ok bool) {
+	dt := s.Time.Sub(previous.Time).Seconds()
+	if dt <= 0 {
+		return 0, 0, false
+	}
+	return (s.X - previous.X) / dt, (s.Y - previous.Y) / dt, true
+}
+
+// Masked hides the pilot's contact details from the public: names become
+// initials, only the first letter and domain of the email and the last two
+// digits of the phone number are kept
+func (p Pilot) Masked() Pilot {
+	p.FirstName = initial(p.FirstName)
+	p.LastName = initial(p.LastName)
+	if local, domain, ok := strings.Cut(p.Email, "@"); ok && len(local) != 0 {
+		p.Email = strings.TrimSuffix(initial(local), ".") + "***@" + domain
+	} else {
+		p.Email = ""
+	}
+	if len(p.PhoneNumber) > 2 {
+		p.PhoneNumber = strings.Repeat("*", len(p.PhoneNumber)-2) + p.PhoneNumber[len(p.PhoneNumber)-2:]
+	} else {
+		p.PhoneNumber = ""
+	}
+	return p
+}
+
+func (v Violation) Masked() Violation {
+	v.Pilot = v.Pilot.Masked()
+	return v
+}
+
+func initial(name string) string {
+	for _, r := range name {
+		return string(r) + "."
+	}
+	return ""
 }
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Reasons a record is rejected
+const (
+	ReasonMissing   = "missing"
+	ReasonNotFinite = "not_finite"
+	ReasonMalformed = "malformed"
+)
+
+// ValidationError describes the first problem found in a record received
+// from the upstream
+type ValidationError struct {
+	// Record is the kind of record, e.g. "drone"
+	Record string
+	// ID identifies the record when it has an identifier
+	ID     string
+	Field  string
+	Reason string
+	// Err is the decoding error behind a malformed field, if any
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("invalid %s: %s is %s", e.Record, e.Field, e.Reason)
+	if len(e.ID) != 0 {
+		msg = fmt.Sprintf("invalid %s %s: %s is %s", e.Record, e.ID, e.Field, e.Reason)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Key names the rejection reason for counting rejected records
+func (e *ValidationError) Key() string {
+	return e.Record + "." + e.Field + "." + e.Reason
+}
+
+// AsValidationError unwraps err into a *ValidationError
+func AsValidationError(err error) (*ValidationError, bool) {
+	var ve *ValidationError
+	ok := errors.As(err, &ve)
+	return ve, ok
+}
+
+// Validate checks the report itself, its drones are validated separately so
+// that a single bad drone does not discard the whole report
+func (r Report) Validate() error {
+	invalid := func(field, reason string) error {
+		return &ValidationError{Record: "report", ID: r.DeviceInformation.DeviceId, Field: field, Reason: reason}
+	}
+
+	if len(r.DeviceInformation.DeviceId) == 0 {
+		return invalid("deviceInformation.deviceId", ReasonMissing)
+	}
+	if r.Capture.SnapshotTimestamp.IsZero() {
+		return invalid("capture.snapshotTimestamp", ReasonMissing)
+	}
+	return nil
+}
+
+func (d Drone) Validate() error {
+	invalid := func(field, reason string) error {
+		return &ValidationError{Record: "drone", ID: d.SerialNumber, Field: field, Reason: reason}
+	}
+
+	if len(d.SerialNumber) == 0 {
+		return invalid("serialNumber", ReasonMissing)
+	}
+	if !isFinite(d.PositionX) {
+		return invalid("positionX", ReasonNotFinite)
+	}
+	if !isFinite(d.PositionY) {
+		return invalid("positionY", ReasonNotFinite)
+	}
+	if !isFinite(d.Altitude) {
+		return invalid("altitude", ReasonNotFinite)
+	}
+	return nil
+}
+
+func (p Pilot) Validate() error {
+	invalid := func(field, reason string) error {
+		return &ValidationError{Record: "pilot", ID: p.PilotID, Field: field, Reason: reason}
+	}
+
+	if len(p.PilotID) == 0 {
+		return invalid("pilotId", ReasonMissing)
+	}
+	if len(p.FirstName) == 0 && len(p.LastName) == 0 {
+		return invalid("name", ReasonMissing)
+	}
+	return nil
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
@@ -0,0 +1,291 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Email is a Sink that sends an alert for every new violator and
+// periodically a digest of the violations. The templates alert.subject,
+// alert.body, digest.subject and digest.body are expected in tmpl.
+type Email struct {
+	cfg    config.Email
+	tmpl   *template.Template
+	logger *slog.Logger
+	now    func() time.Time
+	// send delivers a message to the configured recipients
+	send func(msg []byte) error
+
+	alerts  chan Event
+	stop    chan struct{}
+	workers sync.WaitGroup
+
+	mut    sync.Mutex
+	digest *digest
+}
+
+// digest collects the events of the current period
+type digest struct {
+	from       time.Time
+	violations int
+	offenders  map[string]*Offender
+	closest    *Event
+}
+
+// Offender is a pilot in a digest
+type Offender struct {
+	Pilot models.Pilot
+	// Site of the latest violation
+	Site            string
+	Violations      int
+	ClosestDistance float64
+}
+
+type digestData struct {
+	Period          string
+	From, To        time.Time
+	Violations      int
+	Offenders       []Offender
+	RepeatOffenders []Offender
+	Closest         *Event
+}
+
+func NewEmail(cfg config.Email, tmpl *template.Template, logger *slog.Logger) *Email {
+	e := &Email{
+		cfg:    cfg,
+		tmpl:   tmpl,
+		logger: logger.With("notifier", "email"),
+		now:    time.Now,
+		alerts: make(chan Event, 100),
+		stop:   make(chan struct{}),
+	}
+	e.send = func(msg []byte) error {
+		var auth smtp.Auth
+		if len(cfg.Username) != 0 {
+			host, _, _ := net.SplitHostPort(cfg.Addr)
+			auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+		}
+		return smtp.SendMail(cfg.Addr, auth, cfg.From, cfg.To, msg)
+	}
+	e.digest = newDigest(e.now())
+
+	if cfg.Immediate {
+		e.workers.Add(1)
+		go e.sendAlerts()
+	}
+	if period := digestPeriod(cfg.Digest); period != 0 {
+		e.workers.Add(1)
+		go e.sendDigests(period)
+	}
+	return e
+}
+
+func digestPeriod(digest string) time.Duration {
+	switch digest {
+	case config.DigestHourly:
+		return time.Hour
+	case config.DigestDaily:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+func newDigest(from time.Time) *digest {
+	return &digest{from: from, offenders: make(map[string]*Offender)}
+}
+
+func (e *Email) Notify(event Event) {
+	if event.Type != ViolationStarted && event.Type != ViolationCloser {
+		return
+	}
+
+	e.mut.Lock()
+	e.digest.add(event)
+	e.mut.Unlock()
+
+	if !e.cfg.Immediate || event.Type != ViolationStarted {
+		return
+	}
+	select {
+	case e.alerts <- event:
+	default:
+		e.logger.Warn("email alert queue full, dropping alert", "event", event.ID)
+	}
+}
+
+// Close stops sending, the collected digest is discarded
+func (e *Email) Close() {
+	close(e.stop)
+	e.workers.Wait()
+}
+
+// Erase removes the matching pilots from the digest and the queued alerts
+func (e *Email) Erase(match func(models.Pilot) bool) int {
+	erased := 0
+	for n := len(e.alerts); n > 0; n-- {
+		select {
+		case event := <-e.alerts:
+			if match(event.Pilot) {
+				erased++
+				continue
+			}
+			select {
+			case e.alerts <- event:
+			default:
+			}
+		default:
+		}
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	for id, o := range e.digest.offenders {
+		if match(o.Pilot) {
+			delete(e.digest.offenders, id)
+			erased++
+		}
+	}
+	if e.digest.closest != nil && match(e.digest.closest.Pilot) {
+		e.digest.closest = nil
+		for _, o := range e.digest.offenders {
+			if e.digest.closest == nil || o.ClosestDistance < e.digest.closest.ClosestDistance {
+				e.digest.closest = &Event{Type: ViolationCloser, Site: o.Site, Pilot: o.Pilot, ClosestDistance: o.ClosestDistance}
+			}
+		}
+	}
+	return erased
+}
+
+func (d *digest) add(event Event) {
+	if event.Type == ViolationStarted {
+		d.violations++
+	}
+
+	o, ok := d.offenders[event.Pilot.PilotID]
+	if !ok {
+		o = &Offender{Pilot: event.Pilot, ClosestDistance: event.ClosestDistance}
+		d.offenders[event.Pilot.PilotID] = o
+	}
+	o.Site = event.Site
+	if event.Type == ViolationStarted {
+		o.Violations++
+	}
+	o.ClosestDistance = min(o.ClosestDistance, event.ClosestDistance)
+
+	if d.closest == nil || event.ClosestDistance < d.closest.ClosestDistance {
+		d.closest = &event
+	}
+}
+
+func (e *Email) sendAlerts() {
+	defer e.workers.Done()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case event := <-e.alerts:
+			if err := e.deliver("alert", event); err != nil {
+				e.logger.Error("failed to send email alert", "event", event.ID, "err", err)
+			}
+		}
+	}
+}
+
+func (e *Email) sendDigests(period time.Duration) {
+	defer e.workers.Done()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			if err := e.flushDigest(); err != nil {
+				e.logger.Error("failed to send email digest", "err", err)
+			}
+		}
+	}
+}
+
+// flushDigest sends the digest of the current period, if anything happened,
+// and starts a new one
+func (e *Email) flushDigest() error {
+	now := e.now()
+	e.mut.Lock()
+	d := e.digest
+	e.digest = newDigest(now)
+	e.mut.Unlock()
+
+	if len(d.offenders) == 0 {
+		return nil
+	}
+
+	data := digestData{
+		Period:     strings.ToUpper(e.cfg.Digest[:1]) + e.cfg.Digest[1:],
+		From:       d.from,
+		To:         now,
+		Violations: d.violations,
+		Closest:    d.closest,
+	}
+	for _, o := range d.offenders {
+		data.Offenders = append(data.Offenders, *o)
+	}
+	// Most violations first, then the closest
+	slices.SortFunc(data.Offenders, func(a, b Offender) int {
+		if a.Violations != b.Violations {
+			return b.Violations - a.Violations
+		}
+		if a.ClosestDistance < b.ClosestDistance {
+			return -1
+		}
+		if a.ClosestDistance > b.ClosestDistance {
+			return 1
+		}
+		return strings.Compare(a.Pilot.PilotID, b.Pilot.PilotID)
+	})
+	for _, o := range data.Offenders {
+		if o.Violations > 1 {
+			data.RepeatOffenders = append(data.RepeatOffenders, o)
+		}
+	}
+	return e.deliver("digest", data)
+}
+
+// deliver renders the subject and body templates of kind and sends them
+func (e *Email) deliver(kind string, data any) error {
+	subject := new(bytes.Buffer)
+	if err := e.tmpl.ExecuteTemplate(subject, kind+".subject", data); err != nil {
+		return err
+	}
+	body := new(bytes.Buffer)
+	if err := e.tmpl.ExecuteTemplate(body, kind+".body", data); err != nil {
+		return err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", e.cfg.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(e.cfg.To, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject.String()))
+	fmt.Fprintf(msg, "Date: %s\r\n", e.now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.Write(body.Bytes())
+
+	if err := e.send(msg.Bytes()); err != nil {
+		return err
+	}
+	e.logger.Info("email sent", "kind", kind, "to", len(e.cfg.To))
+	return nil
+}
@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	reaktorbirdnest "reaktor-birdnest"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestEmailAlert(t *testing.T) {
+	addr, messages := startSMTP(t)
+	e := newTestEmail(t, config.Email{Addr: addr, From: "birdnest@example.com", To: []string{"nest@example.com"}, Immediate: true})
+
+	e.Notify(Event{ID: "1", Type: ViolationStarted, Site: "north", Time: time.Now(), Pilot: pilot("P-1", "Ada"), ClosestDistance: 42.5})
+	// Getting closer is only reported in the digest
+	e.Notify(Event{ID: "2", Type: ViolationCloser, Site: "north", Time: time.Now(), Pilot: pilot("P-1", "Ada"), ClosestDistance: 20})
+
+	msg := receive(t, messages)
+	for _, expected := range []string{"To: nest@example.com", "Subject: New no-fly zone violation at north", "Ada Lovelace", "42.50 m"} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("Expected alert to contain %q, but was:\n%s", expected, msg)
+		}
+	}
+	select {
+	case msg := <-messages:
+		t.Errorf("Expected a single alert, but also got:\n%s", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	addr, messages := startSMTP(t)
+	e := newTestEmail(t, config.Email{Addr: addr, From: "birdnest@example.com", To: []string{"nest@example.com"}, Digest: config.DigestDaily})
+
+	if err := e.flushDigest(); err != nil {
+		t.Fatal(err)
+	}
+	events := []Event{
+		{Type: ViolationStarted, Site: "north", Pilot: pilot("P-1", "Ada"), ClosestDistance: 80},
+		{Type: ViolationStarted, Site: "north", Pilot: pilot("P-2", "Grace"), ClosestDistance: 60},
+		{Type: ViolationCloser, Site: "north", Pilot: pilot("P-2", "Grace"), ClosestDistance: 12.3},
+		{Type: ViolationStarted, Site: "south", Pilot: pilot("P-1", "Ada"), ClosestDistance: 70},
+	}
+	for _, event := range events {
+		e.Notify(event)
+	}
+	if err := e.flushDigest(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The empty digest was not sent
+	msg := receive(t, messages)
+	for _, expected := range []string{
+		"Subject: Daily no-fly zone digest: 3 violations",
+		"Closest approach: 12.30 m by Grace Lovelace at north",
+		"Repeat offenders:\n  Ada Lovelace <p-1@example.com>: 2 violations, closest 70.00 m",
+	} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("Expected digest to contain %q, but was:\n%s", expected, msg)
+		}
+	}
+}
+
+func newTestEmail(t *testing.T, cfg config.Email) *Email {
+	tmpl, err := template.ParseFS(reaktorbirdnest.TemplateFS, "ui/email/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEmail(cfg, tmpl, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func pilot(id, firstName string) models.Pilot {
+	return models.Pilot{PilotID: id, FirstName: firstName, LastName: "Lovelace", Email: strings.ToLower(id) + "@example.com"}
+}
+
+func receive(t *testing.T, messages <-chan string) string {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected an email to be sent.")
+	}
+	return ""
+}
+
+// startSMTP runs a minimal SMTP server that accepts every message and
+// passes the data, with line endings normalised, to the returned channel
+func startSMTP(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return l.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost ready")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		switch command, _, _ := strings.Cut(strings.ToUpper(line), " "); command {
+		case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(c.DotReader())
+			if err != nil {
+				return
+			}
+			messages <- string(data)
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 not implemented")
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"reaktor-birdnest/internal/geo"
+	"reaktor-birdnest/internal/models"
+	"time"
+)
+
+type openEpisode struct {
+	models.Episode
+	// lastInside is where and when the drone was last seen inside
+	lastInside models.Sighting
+}
+
+// Episodes returns the events of the drones that entered or left the zones
+// since the previous report. A drone that is no longer reported leaves where
+// it was last seen. pilot returns the pilot of a drone, empty while unknown.
+// Points are located on the globe when the site has an origin.
+func (t *Tracker) Episodes(zones []models.Zone, origin *geo.Reference, drones []models.Drone, pilot func(serial string) models.Pilot, now time.Time) []Event {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	var events []Event
+	emit := func(eventType string, e *openEpisode) {
+		episode := e.Episode
+		events = append(events, Event{
+			ID:              newEventID(),
+			Type:            eventType,
+			Site:            t.site,
+			Time:            now,
+			Pilot:           pilot(episode.Serial),
+			ClosestDistance: episode.ClosestDistance,
+			Episode:         &episode,
+		})
+	}
+
+	current := make(map[string]models.Sighting, len(drones))
+	for _, drone := range drones {
+		at := models.SightingOf(drone, now)
+		current[drone.SerialNumber] = at
+		distance, inside := models.Inside(zones, drone)
+		e, open := t.episodes[drone.SerialNumber]
+		previous, seen := t.sightings[drone.SerialNumber]
+
+		switch {
+		case inside && !open:
+			e = &openEpisode{
+				Episode: models.Episode{
+					ID:              newEventID(),
+					Serial:          drone.SerialNumber,
+					Manufacturer:    drone.Manufacturer,
+					Model:           drone.Model,
+					Firmware:        drone.Firmware,
+					Entered:         now,
+					ClosestDistance: distance,
+					ClosestPoint:    locate(origin, at.X, at.Y),
+					EntryPoint:      locate(origin, at.X, at.Y),
+				},
+				lastInside: at,
+			}
+			if seen {
+				if point, after, ok := crossing(zones, previous, at); ok {
+					e.Entered = previous.Time.Add(seconds(after))
+					e.EntryPoint = locate(origin, point.X, point.Y)
+				}
+			}
+			e.Duration = now.Sub(e.Entered).Seconds()
+			t.episodes[drone.SerialNumber] = e
+			emit(ZoneEntered, e)
+		case inside:
+			if distance < e.ClosestDistance {
+				e.ClosestDistance = distance
+				e.ClosestPoint = locate(origin, at.X, at.Y)
+			}
+			e.TimeInside += now.Sub(e.lastInside.Time).Seconds()
+			e.Duration = now.Sub(e.Entered).Seconds()
+			e.lastInside = at
+		case open:
+			// Crossed the boundary between the reports, seen backwards from
+			// outside
+			exit := locate(origin, e.lastInside.X, e.lastInside.Y)
+			exited := e.lastInside.Time
+			if point, before, ok := crossing(zones, at, e.lastInside); ok {
+				exit, exited = locate(origin, point.X, point.Y), now.Add(-seconds(before))
+			}
+			t.exit(e, exit, exited)
+			emit(ZoneExited, e)
+		}
+	}
+	for serial, e := range t.episodes {
+		if _, ok := current[serial]; !ok {
+			t.exit(e, locate(origin, e.lastInside.X, e.lastInside.Y), e.lastInside.Time)
+			emit(ZoneExited, e)
+		}
+	}
+	t.sightings = current
+	return events
+}
+
+// exit closes the episode, the caller must hold t.mut
+func (t *Tracker) exit(e *openEpisode, at models.Point, exited time.Time) {
+	e.Exited = exited
+	e.ExitPoint = &at
+	e.Duration = exited.Sub(e.Entered).Seconds()
+	delete(t.episodes, e.Serial)
+}
+
+// locate returns the point at x, y in meters with its latitude and longitude
+// when the origin is known
+func locate(origin *geo.Reference, x, y float64) models.Point {
+	p := models.Point{X: x, Y: y}
+	p.Lat, p.Lon = origin.Locate(x, y)
+	return p
+}
+
+// crossing returns where a drone moving in a straight line from outside the
+// zones to inside of one of them first crossed a boundary, and how many
+// seconds it took to get there. From may be the later sighting to follow the
+// drone backwards.
+func crossing(zones []models.Zone, from, to models.Sighting) (models.Point, float64, bool) {
+	earlier, later, backwards := from, to, to.Time.Before(from.Time)
+	if backwards {
+		earlier, later = to, from
+	}
+	vx, vy, ok := later.Velocity(earlier)
+	if !ok {
+		return models.Point{}, 0, false
+	}
+	if backwards {
+		vx, vy = -vx, -vy
+	}
+	t, ok := models.Incursion(zones, from.X, from.Y, vx, vy, later.Time.Sub(earlier.Time).Seconds())
+	if !ok {
+		return models.Point{}, 0, false
+	}
+	return models.Point{X: from.X + vx*t, Y: from.Y + vy*t}, t, true
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
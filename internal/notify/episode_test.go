@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"math"
+	"reaktor-birdnest/internal/geo"
+	"reaktor-birdnest/internal/models"
+	"testing"
+	"time"
+)
+
+func TestTrackerEpisodes(t *testing.T) {
+	tracker := NewTracker("north", nil)
+	var origin *geo.Reference
+	zones := []models.Zone{{OriginX: 250000, OriginY: 250000, Radius: 100}}
+	pilot := func(serial string) models.Pilot { return models.Pilot{PilotID: "P-1"} }
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	report := func(after time.Duration, x ...float64) []Event {
+		var drones []models.Drone
+		for _, x := range x {
+			drones = append(drones, models.Drone{SerialNumber: "SN-1", PositionX: x * 1000, PositionY: 250000})
+		}
+		return tracker.Episodes(zones, origin, drones, pilot, start.Add(after))
+	}
+
+	// Flying east at 10 m/s, crossing the boundary at x = 150 m after 5 s
+	expectEvents(t, report(0, 100))
+	events := report(10*time.Second, 200)
+	expectEvents(t, events, "P-1 "+ZoneEntered)
+	first := events[0].Episode
+	if !first.Entered.Equal(start.Add(5*time.Second)) || first.EntryPoint != (models.Point{X: 150, Y: 250}) || first.ExitPoint != nil {
+		t.Errorf("Expected to enter at 150 m after 5 s, but was %v.", first)
+	}
+
+	expectEvents(t, report(20*time.Second, 260))
+	// Leaving at 14 m/s crosses x = 350 m 50/14 s before the report
+	events = report(30*time.Second, 400)
+	expectEvents(t, events, "P-1 "+ZoneExited)
+	e := events[0].Episode
+	exited := start.Add(30 * time.Second).Add(-seconds(50.0 / 14))
+	if e.ID != first.ID || !e.Exited.Equal(exited) || e.ExitPoint == nil || math.Abs(e.ExitPoint.X-350) > 1e-9 {
+		t.Errorf("Expected the episode to close at 350 m, but was %v.", e)
+	}
+	if e.TimeInside != 10 || math.Abs(e.Duration-exited.Sub(first.Entered).Seconds()) > 1e-9 || e.ClosestDistance != 10 || e.ClosestPoint.X != 260 {
+		t.Errorf("Expected 10 s inside at best 10 m from the origin, but was %v.", e)
+	}
+
+	// Re-entering starts a new episode, which ends where the drone was last
+	// seen when it is no longer reported
+	events = report(40*time.Second, 300)
+	expectEvents(t, events, "P-1 "+ZoneEntered)
+	if events[0].Episode.ID == first.ID {
+		t.Errorf("Expected a new episode, but was %v.", events[0].Episode)
+	}
+	events = report(50 * time.Second)
+	expectEvents(t, events, "P-1 "+ZoneExited)
+	if e := events[0].Episode; !e.Exited.Equal(start.Add(40*time.Second)) || *e.ExitPoint != (models.Point{X: 300, Y: 250}) || e.Duration != 5 {
+		t.Errorf("Expected the episode to end at the last sighting, but was %v.", e)
+	}
+
+	// Points are located on the globe when the site has an origin
+	origin = &geo.Reference{Lat: 60, Lon: 25}
+	events = report(60*time.Second, 250)
+	expectEvents(t, events, "P-1 "+ZoneEntered)
+	if p := events[0].Episode.EntryPoint; p.Lat == nil || math.Abs(*p.Lat-60.002246) > 1e-6 || math.Abs(*p.Lon-25.004492) > 1e-6 {
+		t.Errorf("Expected the entry point at 60.002246, 25.004492, but was %v, %v.", p.Lat, p.Lon)
+	}
+}
@@ -0,0 +1,221 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"reaktor-birdnest/internal/models"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	// ViolationStarted is sent when a pilot starts violating a zone
+	ViolationStarted = "violation.started"
+	// ViolationCloser is sent when a pilot's closest distance drops below
+	// one of the configured thresholds
+	ViolationCloser = "violation.closer"
+	// ViolationUpdated is sent whenever a pilot's closest distance changes
+	ViolationUpdated = "violation.updated"
+	// ViolationExpired is sent when a violation has not been seen for the
+	// persistence TTL
+	ViolationExpired = "violation.expired"
+	// ViolationErased is sent when a pilot's data is erased on request, it
+	// carries the pilot id only
+	ViolationErased = "violation.erased"
+	// DronePositions carries every drone of a report
+	DronePositions = "drone.positions"
+	// IncursionPredicted is sent when a drone outside the zones is heading
+	// into one, it carries the drone and the ETA but no pilot
+	IncursionPredicted = "incursion.predicted"
+	// ZoneEntered and ZoneExited open and close an episode of a drone inside
+	// the zones, the pilot is empty when the lookup has not finished
+	ZoneEntered = "zone.entered"
+	ZoneExited  = "zone.exited"
+)
+
+type Event struct {
+	ID              string       `json:"id"`
+	Type            string       `json:"type"`
+	Site            string       `json:"site"`
+	Time            time.Time    `json:"time"`
+	Pilot           models.Pilot `json:"pilot"`
+	ClosestDistance float64      `json:"closestDistance"`
+	// Threshold is the distance that was crossed by a ViolationCloser event
+	Threshold float64 `json:"threshold,omitempty"`
+	// Drones are set on DronePositions and IncursionPredicted events only
+	Drones []models.Drone `json:"drones,omitempty"`
+	// ETA is the seconds until the drone of an IncursionPredicted event
+	// enters the zone
+	ETA float64 `json:"eta,omitempty"`
+	// Episode is set on ZoneEntered and ZoneExited events only
+	Episode *models.Episode `json:"episode,omitempty"`
+}
+
+// Sink receives the events of every site
+type Sink interface {
+	Notify(event Event)
+}
+
+// Sinks sends every event to all of its sinks
+type Sinks []Sink
+
+func (s Sinks) Notify(event Event) {
+	for _, sink := range s {
+		sink.Notify(event)
+	}
+}
+
+// Eraser is implemented by sinks that hold on to pilot data
+type Eraser interface {
+	// Erase forgets the matching pilots and returns how many events or
+	// entries it removed
+	Erase(match func(models.Pilot) bool) int
+}
+
+// Erase erases the matching pilots from every sink that holds pilot data
+func (s Sinks) Erase(match func(models.Pilot) bool) int {
+	erased := 0
+	for _, sink := range s {
+		if eraser, ok := sink.(Eraser); ok {
+			erased += eraser.Erase(match)
+		}
+	}
+	return erased
+}
+
+// Tracker turns the violations dispatched for a site into events by
+// comparing them to the previously dispatched ones
+type Tracker struct {
+	site       string
+	thresholds []float64
+
+	mut sync.Mutex
+	// by pilot id
+	previous map[string]models.Violation
+	// episodes are the open episodes and sightings the drones of the
+	// previous report, both by serial
+	episodes  map[string]*openEpisode
+	sightings map[string]models.Sighting
+}
+
+func NewTracker(site string, thresholds []float64) *Tracker {
+	t := &Tracker{
+		site:       site,
+		thresholds: slices.Clone(thresholds),
+		previous:   make(map[string]models.Violation),
+		episodes:   make(map[string]*openEpisode),
+	}
+	// Strictest first
+	slices.Sort(t.thresholds)
+	return t
+}
+
+// Update returns the events caused by the current violations
+func (t *Tracker) Update(violations []models.Violation, now time.Time) []Event {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	var events []Event
+	emit := func(eventType string, v models.Violation) *Event {
+		events = append(events, Event{
+			ID:              newEventID(),
+			Type:            eventType,
+			Site:            t.site,
+			Time:            now,
+			Pilot:           v.Pilot,
+			ClosestDistance: v.ClosestDistance,
+		})
+		return &events[len(events)-1]
+	}
+
+	current := make(map[string]models.Violation, len(violations))
+	for _, v := range violations {
+		current[v.Pilot.PilotID] = v
+
+		previous, seen := t.previous[v.Pilot.PilotID]
+		switch {
+		case !seen:
+			emit(ViolationStarted, v)
+		case v.ClosestDistance != previous.ClosestDistance:
+			emit(ViolationUpdated, v)
+			if threshold, crossed := t.crossed(previous.ClosestDistance, v.ClosestDistance); crossed {
+				emit(ViolationCloser, v).Threshold = threshold
+			}
+		}
+	}
+	for id, v := range t.previous {
+		if _, ok := current[id]; !ok {
+			emit(ViolationExpired, v)
+		}
+	}
+	// Expired violations start a new episode when the pilot returns
+	t.previous = current
+	return events
+}
+
+// Erase forgets the matching pilots without expiring their violations and
+// returns the ViolationErased events telling the sinks to do the same
+func (t *Tracker) Erase(match func(models.Pilot) bool, now time.Time) []Event {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	var events []Event
+	for id, v := range t.previous {
+		if !match(v.Pilot) {
+			continue
+		}
+		delete(t.previous, id)
+		events = append(events, Event{
+			ID:    newEventID(),
+			Type:  ViolationErased,
+			Site:  t.site,
+			Time:  now,
+			Pilot: models.Pilot{PilotID: id},
+		})
+	}
+	return events
+}
+
+// Positions returns the event carrying the drones of a report
+func (t *Tracker) Positions(drones []models.Drone, now time.Time) Event {
+	return Event{
+		ID:     newEventID(),
+		Type:   DronePositions,
+		Site:   t.site,
+		Time:   now,
+		Drones: drones,
+	}
+}
+
+// Incursion returns the event warning that the drone will enter a zone in
+// eta seconds, distance is its current distance to the nearest zone origin
+func (t *Tracker) Incursion(drone models.Drone, distance, eta float64, now time.Time) Event {
+	return Event{
+		ID:              newEventID(),
+		Type:            IncursionPredicted,
+		Site:            t.site,
+		Time:            now,
+		ClosestDistance: distance,
+		Drones:          []models.Drone{drone},
+		ETA:             eta,
+	}
+}
+
+// crossed returns the strictest threshold between the previous and the
+// current distance
+func (t *Tracker) crossed(previous, current float64) (float64, bool) {
+	for _, threshold := range t.thresholds {
+		if current <= threshold && previous > threshold {
+			return threshold, true
+		}
+	}
+	return 0, false
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"reaktor-birdnest/internal/models"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTrackerEvents(t *testing.T) {
+	tracker := NewTracker("north", []float64{25, 50})
+	now := time.Now()
+	expectEvents(t, tracker.Update([]models.Violation{violation("P-1", 90)}, now), "P-1 "+ViolationStarted)
+
+	events := tracker.Update([]models.Violation{violation("P-1", 80), violation("P-2", 95)}, now)
+	expectEvents(t, events, "P-1 "+ViolationUpdated, "P-2 "+ViolationStarted)
+	for _, event := range events {
+		if event.Site != "north" || len(event.ID) == 0 {
+			t.Errorf("Expected event to carry the site and an id, but was %v.", event)
+		}
+	}
+
+	// Crossing both thresholds at once reports the stricter one
+	events = tracker.Update([]models.Violation{violation("P-1", 20), violation("P-2", 95)}, now)
+	expectEvents(t, events, "P-1 "+ViolationUpdated, "P-1 "+ViolationCloser)
+	if len(events) == 2 && events[1].Threshold != 25 {
+		t.Errorf("Expected P-1 to cross the 25 m threshold, but was %v.", events[1].Threshold)
+	}
+
+	events = tracker.Update([]models.Violation{violation("P-1", 20)}, now)
+	expectEvents(t, events, "P-2 "+ViolationExpired)
+
+	// P-2 expired in the previous update, so coming back is a new violation
+	events = tracker.Update([]models.Violation{violation("P-1", 20), violation("P-2", 70)}, now)
+	expectEvents(t, events, "P-2 "+ViolationStarted)
+}
+
+func TestTrackerErase(t *testing.T) {
+	tracker := NewTracker("north", nil)
+	now := time.Now()
+	tracker.Update([]models.Violation{violation("P-1", 90), violation("P-2", 80)}, now)
+
+	events := tracker.Erase(func(p models.Pilot) bool { return p.PilotID == "P-1" }, now)
+	expectEvents(t, events, "P-1 "+ViolationErased)
+	if len(events) == 1 && events[0].Pilot.FirstName != "" {
+		t.Errorf("Expected the erased event to carry the pilot id only, but was %v.", events[0].Pilot)
+	}
+
+	// The erased violation does not expire later
+	events = tracker.Update([]models.Violation{violation("P-2", 80)}, now)
+	expectEvents(t, events)
+}
+
+func expectEvents(t *testing.T, events []Event, expected ...string) {
+	t.Helper()
+	var actual []string
+	for _, event := range events {
+		actual = append(actual, event.Pilot.PilotID+" "+event.Type)
+	}
+	if !slices.Equal(actual, expected) {
+		t.Errorf("Expected events %v, but were %v.", expected, actual)
+	}
+}
+
+func violation(pilotID string, distance float64) models.Violation {
+	return models.Violation{
+		Pilot:           models.Pilot{PilotID: pilotID, FirstName: "Test"},
+		ClosestDistance: distance,
+	}
+}
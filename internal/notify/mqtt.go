@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"encoding/json"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"log/slog"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"strings"
+	"time"
+)
+
+// MQTTEvents maps event types to the {event} of the events topic
+var MQTTEvents = map[string]string{
+	ViolationStarted: "added",
+	ViolationUpdated: "updated",
+	ViolationExpired: "expired",
+	ViolationErased:  "erased",
+	// Only published to the events topic
+	IncursionPredicted: "predicted",
+	ZoneEntered:        "entered",
+	ZoneExited:         "exited",
+}
+
+// MQTT is a Sink that publishes violation events, the current violations as
+// retained messages and drone positions to a broker
+type MQTT struct {
+	cfg    config.MQTT
+	client mqtt.Client
+	logger *slog.Logger
+}
+
+// position is the payload of the positions topic
+type position struct {
+	Site string    `json:"site"`
+	Time time.Time `json:"time"`
+	// Embedded to flatten the drone into the payload
+	models.Drone
+}
+
+// NewMQTT connects in the background and keeps reconnecting. Messages with
+// QoS 1 or 2 published while disconnected are sent once connected.
+func NewMQTT(cfg config.MQTT, logger *slog.Logger) *MQTT {
+	logger = logger.With("notifier", "mqtt", "broker", cfg.Broker)
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			logger.Info("connected to MQTT broker")
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Warn("lost connection to MQTT broker", "err", err)
+		})
+
+	m := &MQTT{
+		cfg:    cfg,
+		client: mqtt.NewClient(opts),
+		logger: logger,
+	}
+	m.client.Connect()
+	return m
+}
+
+func (m *MQTT) Notify(event Event) {
+	if event.Type == DronePositions {
+		for _, drone := range event.Drones {
+			topic := m.topic(m.cfg.Topics.Positions, event, map[string]string{"serial": drone.SerialNumber})
+			m.publish(topic, false, position{Site: event.Site, Time: event.Time, Drone: drone})
+		}
+		return
+	}
+
+	name, ok := MQTTEvents[event.Type]
+	if !ok {
+		return
+	}
+	if m.cfg.MaskPilots {
+		// The id stays for the state topic
+		event.Pilot = event.Pilot.Masked()
+	}
+	m.publish(m.topic(m.cfg.Topics.Events, event, map[string]string{"event": name}), false, event)
+	if event.Type == IncursionPredicted || event.Type == ZoneEntered || event.Type == ZoneExited {
+		return
+	}
+
+	state := m.topic(m.cfg.Topics.State, event, nil)
+	if event.Type == ViolationExpired || event.Type == ViolationErased {
+		// An empty retained message removes the retained one
+		m.publishRaw(state, true, nil)
+	} else {
+		m.publish(state, true, event)
+	}
+}
+
+// Close disconnects after waiting briefly for queued messages
+func (m *MQTT) Close() {
+	m.client.Disconnect(250)
+}
+
+// topic fills in the template, an empty template leaves the topic empty
+func (m *MQTT) topic(template string, event Event, values map[string]string) string {
+	if len(template) == 0 {
+		return ""
+	}
+	replacements := []string{"{site}", event.Site, "{pilot}", event.Pilot.PilotID}
+	for k, v := range values {
+		replacements = append(replacements, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+func (m *MQTT) publish(topic string, retained bool, payload any) {
+	if len(topic) == 0 {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		m.logger.Error("failed to encode MQTT message", "topic", topic, "err", err)
+		return
+	}
+	m.publishRaw(topic, retained, data)
+}
+
+func (m *MQTT) publishRaw(topic string, retained bool, data []byte) {
+	if len(topic) == 0 {
+		return
+	}
+	token := m.client.Publish(topic, m.cfg.QoS, retained, data)
+	go func() {
+		if token.WaitTimeout(30*time.Second) && token.Error() != nil {
+			m.logger.Error("failed to publish MQTT message", "topic", topic, "err", token.Error())
+		}
+	}()
+}
@@ -0,0 +1,242 @@
+package notify
+
+import (
+	"encoding/json"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"io"
+	"log/slog"
+	"net"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMQTTPublishesEventsAndState(t *testing.T) {
+	broker := startBroker(t)
+	cfg := config.Default().Notify.MQTT
+	cfg.Broker = broker
+	m := NewMQTT(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer m.Close()
+
+	messages := subscribe(t, broker, "birdnest/#")
+
+	started := Event{ID: "1", Type: ViolationStarted, Site: "north", Pilot: pilot("P-1", "Ada"), ClosestDistance: 42}
+	m.Notify(started)
+	m.Notify(Event{ID: "2", Type: DronePositions, Site: "north", Drones: []models.Drone{{SerialNumber: "SN-1", PositionX: 1, PositionY: 2}}})
+	// Not published to MQTT
+	m.Notify(Event{ID: "3", Type: ViolationCloser, Site: "north", Pilot: pilot("P-1", "Ada"), ClosestDistance: 20})
+
+	expectMessage(t, messages, "birdnest/north/violations/events/added", func(payload []byte) {
+		var event Event
+		json.Unmarshal(payload, &event)
+		if event.ID != "1" || event.Pilot.PilotID != "P-1" {
+			t.Errorf("Expected the started event, but was %s.", payload)
+		}
+		if event.Pilot.FirstName != "A." || strings.Contains(string(payload), "Ada") {
+			t.Errorf("Expected the pilot to be masked, but was %s.", payload)
+		}
+	})
+	expectMessage(t, messages, "birdnest/north/violations/current/P-1", nil)
+	expectMessage(t, messages, "birdnest/north/drones/SN-1", func(payload []byte) {
+		if !strings.Contains(string(payload), `"serialNumber":"SN-1"`) || !strings.Contains(string(payload), `"site":"north"`) {
+			t.Errorf("Expected a flat drone position, but was %s.", payload)
+		}
+	})
+
+	// A late subscriber gets the current violations
+	current := subscribe(t, broker, "birdnest/north/violations/current/+")
+	expectMessage(t, current, "birdnest/north/violations/current/P-1", nil)
+
+	m.Notify(Event{ID: "4", Type: ViolationExpired, Site: "north", Pilot: pilot("P-1", "Ada"), ClosestDistance: 20})
+	expectMessage(t, messages, "birdnest/north/violations/events/expired", nil)
+	expectMessage(t, messages, "birdnest/north/violations/current/P-1", func(payload []byte) {
+		if len(payload) != 0 {
+			t.Errorf("Expected the retained state to be cleared, but was %s.", payload)
+		}
+	})
+
+	late := subscribe(t, broker, "birdnest/north/violations/current/+")
+	select {
+	case msg := <-late:
+		t.Errorf("Expected no retained state after expiring, but got %s.", msg.Topic())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func subscribe(t *testing.T, broker, filter string) <-chan mqtt.Message {
+	messages := make(chan mqtt.Message, 100)
+	opts := mqtt.NewClientOptions().AddBroker(broker).SetClientID("test-" + filter)
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("Expected to connect to the broker, but got %v.", token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+
+	token := client.Subscribe(filter, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		messages <- msg
+	})
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("Expected to subscribe, but got %v.", token.Error())
+	}
+	return messages
+}
+
+func expectMessage(t *testing.T, messages <-chan mqtt.Message, topic string, check func(payload []byte)) {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		if msg.Topic() != topic {
+			t.Errorf("Expected a message on %s, but got one on %s.", topic, msg.Topic())
+			return
+		}
+		if check != nil {
+			check(msg.Payload())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a message on %s.", topic)
+	}
+}
+
+// testBroker is an embedded MQTT 3.1.1 broker supporting what the tests
+// need: QoS 0 and 1, retained messages and wildcard subscriptions
+type testBroker struct {
+	mut      sync.Mutex
+	retained map[string][]byte
+	subs     map[*brokerConn][]string
+}
+
+type brokerConn struct {
+	net.Conn
+	mut sync.Mutex
+}
+
+func (c *brokerConn) write(p packets.ControlPacket) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	p.Write(c)
+}
+
+func startBroker(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	b := &testBroker{retained: make(map[string][]byte), subs: make(map[*brokerConn][]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(&brokerConn{Conn: conn})
+		}
+	}()
+	return "tcp://" + l.Addr().String()
+}
+
+func (b *testBroker) serve(c *brokerConn) {
+	defer func() {
+		b.mut.Lock()
+		delete(b.subs, c)
+		b.mut.Unlock()
+		c.Close()
+	}()
+
+	for {
+		cp, err := packets.ReadPacket(c)
+		if err != nil {
+			return
+		}
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			c.write(packets.NewControlPacket(packets.Connack))
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = p.Qoss
+			c.write(ack)
+
+			b.mut.Lock()
+			b.subs[c] = append(b.subs[c], p.Topics...)
+			var retained []*packets.PublishPacket
+			for topic, payload := range b.retained {
+				if matchesAny(p.Topics, topic) {
+					retained = append(retained, publishPacket(topic, payload, true))
+				}
+			}
+			b.mut.Unlock()
+			for _, pub := range retained {
+				c.write(pub)
+			}
+		case *packets.PublishPacket:
+			if p.Qos == 1 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				c.write(ack)
+			}
+			b.publish(p)
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *testBroker) publish(p *packets.PublishPacket) {
+	b.mut.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.TopicName)
+		} else {
+			b.retained[p.TopicName] = p.Payload
+		}
+	}
+	var subscribers []*brokerConn
+	for c, filters := range b.subs {
+		if matchesAny(filters, p.TopicName) {
+			subscribers = append(subscribers, c)
+		}
+	}
+	b.mut.Unlock()
+
+	for _, c := range subscribers {
+		c.write(publishPacket(p.TopicName, p.Payload, false))
+	}
+}
+
+func publishPacket(topic string, payload []byte, retain bool) *packets.PublishPacket {
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.TopicName = topic
+	pub.Payload = payload
+	pub.Retain = retain
+	return pub
+}
+
+func matchesAny(filters []string, topic string) bool {
+	for _, filter := range filters {
+		if matchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchTopic(filter, topic string) bool {
+	f, tp := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(tp) || (level != "+" && level != tp[i]) {
+			return false
+		}
+	}
+	return len(f) == len(tp)
+}
@@ -0,0 +1,120 @@
+package notify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reaktor-birdnest/internal/models"
+	"strings"
+)
+
+// delivery is an event waiting to be sent to an endpoint
+type delivery struct {
+	Endpoint string `json:"endpoint"`
+	Event    Event  `json:"event"`
+	Attempts int    `json:"attempts"`
+	// name of the file in the outbox
+	name string
+}
+
+// outbox keeps every pending delivery in its own file until it succeeds
+type outbox struct {
+	dir string
+}
+
+func openOutbox(dir string) (*outbox, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &outbox{dir: dir}, nil
+}
+
+func newDelivery(endpoint string, event Event) *delivery {
+	hash := sha256.Sum256([]byte(endpoint))
+	return &delivery{
+		Endpoint: endpoint,
+		Event:    event,
+		// Sorting the names orders the deliveries by event time
+		name: fmt.Sprintf("%020d-%s-%s.json", event.Time.UnixNano(), event.ID, hex.EncodeToString(hash[:4])),
+	}
+}
+
+// save writes the delivery atomically so that a crash never leaves half a
+// file behind
+func (o *outbox) save(d *delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(o.dir, d.name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(o.dir, d.name))
+}
+
+func (o *outbox) remove(d *delivery) error {
+	return os.Remove(filepath.Join(o.dir, d.name))
+}
+
+// fail keeps a delivery that ran out of attempts for inspection without
+// retrying it after a restart
+func (o *outbox) fail(d *delivery) error {
+	path := filepath.Join(o.dir, d.name)
+	return os.Rename(path, path+".failed")
+}
+
+// pending returns the deliveries left over from a previous run, oldest first
+func (o *outbox) pending() ([]*delivery, error) {
+	return o.read(".json")
+}
+
+// erase removes the pending and failed deliveries of the matching pilots and
+// returns them
+func (o *outbox) erase(match func(models.Pilot) bool) ([]*delivery, error) {
+	var erased []*delivery
+	for _, suffix := range []string{".json", ".json.failed"} {
+		deliveries, err := o.read(suffix)
+		if err != nil {
+			return erased, err
+		}
+		for _, d := range deliveries {
+			if !match(d.Event.Pilot) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(o.dir, d.name)); err != nil {
+				return erased, err
+			}
+			erased = append(erased, d)
+		}
+	}
+	return erased, nil
+}
+
+// read returns the deliveries in the files with the suffix, sorted by name
+func (o *outbox) read(suffix string) ([]*delivery, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*delivery
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(o.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		d := &delivery{name: entry.Name()}
+		if err := json.Unmarshal(data, d); err != nil {
+			return nil, fmt.Errorf("corrupt outbox entry %s: %w", entry.Name(), err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,296 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"log/slog"
+	"net/http"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Headers of a webhook request
+const (
+	SignatureHeader = "X-Birdnest-Signature"
+	EventHeader     = "X-Birdnest-Event"
+	DeliveryHeader  = "X-Birdnest-Delivery"
+)
+
+// Webhooks is a Sink that POSTs events as JSON to the configured endpoints.
+// Every delivery is kept in the outbox until the endpoint accepts it.
+type Webhooks struct {
+	cfg       config.Notify
+	outbox    *outbox
+	client    *http.Client
+	logger    *slog.Logger
+	endpoints []*endpoint
+
+	// erased are the names of erased deliveries still in the queues
+	mut    sync.Mutex
+	erased map[string]bool
+
+	stop    chan struct{}
+	workers sync.WaitGroup
+}
+
+type endpoint struct {
+	cfg   config.Webhook
+	queue chan *delivery
+}
+
+// NewWebhooks starts a worker per endpoint and resumes the deliveries left
+// in the outbox by a previous run
+func NewWebhooks(cfg config.Notify, logger *slog.Logger) (*Webhooks, error) {
+	o, err := openOutbox(cfg.Outbox)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Webhooks{
+		cfg:    cfg,
+		outbox: o,
+		client: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   10 * time.Second,
+		},
+		logger: logger,
+		erased: make(map[string]bool),
+		stop:   make(chan struct{}),
+	}
+	for _, c := range cfg.Webhooks {
+		w.endpoints = append(w.endpoints, &endpoint{cfg: c, queue: make(chan *delivery, 1024)})
+	}
+
+	pending, err := o.pending()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range pending {
+		if ep := w.endpoint(d.Endpoint); ep != nil {
+			w.enqueue(ep, d)
+		} else {
+			logger.Warn("outbox has a delivery for an endpoint that is no longer configured", "endpoint", d.Endpoint, "event", d.Event.ID)
+		}
+	}
+	if len(pending) != 0 {
+		logger.Info("resuming webhook deliveries", "pending", len(pending))
+	}
+
+	for _, ep := range w.endpoints {
+		w.workers.Add(1)
+		go w.run(ep)
+	}
+	return w, nil
+}
+
+func (w *Webhooks) Notify(event Event) {
+	for _, ep := range w.endpoints {
+		if !matches(ep.cfg, event) {
+			continue
+		}
+		d := newDelivery(ep.cfg.URL, event)
+		if err := w.outbox.save(d); err != nil {
+			w.logger.Error("failed to save webhook delivery to the outbox", "endpoint", ep.cfg.URL, "event", event.ID, "err", err)
+		}
+		w.enqueue(ep, d)
+	}
+}
+
+// Close stops the workers, undelivered events stay in the outbox
+func (w *Webhooks) Close() {
+	close(w.stop)
+	w.workers.Wait()
+}
+
+// Erase removes the deliveries of the matching pilots from the outbox,
+// including failed ones, so that they are never sent
+func (w *Webhooks) Erase(match func(models.Pilot) bool) int {
+	erased, err := w.outbox.erase(match)
+	if err != nil {
+		w.logger.Error("failed to erase webhook deliveries from the outbox", "err", err)
+	}
+
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	for _, d := range erased {
+		w.erased[d.name] = true
+	}
+	return len(erased)
+}
+
+// skip reports whether the delivery was erased while queued
+func (w *Webhooks) skip(d *delivery) bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if !w.erased[d.name] {
+		return false
+	}
+	delete(w.erased, d.name)
+	return true
+}
+
+func (w *Webhooks) endpoint(url string) *endpoint {
+	for _, ep := range w.endpoints {
+		if ep.cfg.URL == url {
+			return ep
+		}
+	}
+	return nil
+}
+
+func (w *Webhooks) enqueue(ep *endpoint, d *delivery) {
+	select {
+	case ep.queue <- d:
+	default:
+		w.logger.Warn("webhook queue full, delivery stays in the outbox until restart", "endpoint", ep.cfg.URL, "event", d.Event.ID)
+	}
+}
+
+// run delivers the events of an endpoint one at a time so that they arrive
+// in order
+func (w *Webhooks) run(ep *endpoint) {
+	defer w.workers.Done()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case d := <-ep.queue:
+			if !w.deliver(ep, d) {
+				return
+			}
+		}
+	}
+}
+
+// deliver retries until the delivery succeeds, fails permanently or runs
+// out of attempts. It returns false when stopped.
+func (w *Webhooks) deliver(ep *endpoint, d *delivery) bool {
+	logger := w.logger.With("endpoint", ep.cfg.URL, "event", d.Event.ID, "type", d.Event.Type)
+	for {
+		if w.skip(d) {
+			// A retry may have saved it again after it was erased
+			w.outbox.remove(d)
+			logger.Debug("webhook delivery erased")
+			return true
+		}
+		err := w.post(ep.cfg, d.Event)
+		d.Attempts++
+		if err == nil {
+			logger.Debug("webhook delivered", "attempts", d.Attempts)
+			if err := w.outbox.remove(d); err != nil {
+				logger.Error("failed to remove webhook delivery from the outbox", "err", err)
+			}
+			return true
+		}
+
+		if permanent(err) || d.Attempts >= w.cfg.MaxAttempts {
+			logger.Error("webhook delivery failed", "attempts", d.Attempts, "err", err)
+			if err := w.outbox.fail(d); err != nil {
+				logger.Error("failed to mark webhook delivery as failed", "err", err)
+			}
+			return true
+		}
+
+		backoff := w.backoff(d.Attempts)
+		logger.Warn("webhook delivery failed, retrying", "attempts", d.Attempts, "backoff", backoff, "err", err)
+		if err := w.outbox.save(d); err != nil {
+			logger.Error("failed to save webhook delivery to the outbox", "err", err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-w.stop:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+func (w *Webhooks) backoff(attempts int) time.Duration {
+	backoff := w.cfg.InitialBackoff
+	for i := 1; i < attempts && backoff < w.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, w.cfg.MaxBackoff)
+}
+
+func (w *Webhooks) post(cfg config.Webhook, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(DeliveryHeader, event.ID)
+	req.Header.Set(SignatureHeader, Sign(cfg.Secret, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// StatusError is returned when an endpoint rejects a delivery
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("endpoint responded with status %d", e.StatusCode)
+}
+
+// permanent tells apart the client errors that retrying does not fix
+func permanent(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	code := statusErr.StatusCode
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+// Sign returns the signature header value of body, "sha256=" followed by
+// the hex encoded HMAC-SHA256 of the body with the endpoint's secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DefaultWebhookEvents are sent to endpoints without an events filter
+var DefaultWebhookEvents = []string{ViolationStarted, ViolationCloser}
+
+func matches(cfg config.Webhook, event Event) bool {
+	events := cfg.Events
+	if len(events) == 0 {
+		events = DefaultWebhookEvents
+	}
+	if !slices.Contains(events, event.Type) {
+		return false
+	}
+	if len(cfg.Sites) != 0 && !slices.Contains(cfg.Sites, event.Site) {
+		return false
+	}
+	return cfg.MaxDistance == 0 || event.ClosestDistance <= cfg.MaxDistance
+}
@@ -0,0 +1,220 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reaktor-birdnest/internal/config"
+	"reaktor-birdnest/internal/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookRetriesAndSigns(t *testing.T) {
+	var failures atomic.Int32
+	failures.Store(2)
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			t.Errorf("Expected a valid signature, but was %q.", r.Header.Get(SignatureHeader))
+		}
+		var event Event
+		json.Unmarshal(body, &event)
+		received <- event
+	}))
+	defer server.Close()
+
+	cfg := notifyConfig(t, config.Webhook{URL: server.URL, Secret: "secret"})
+	w, err := NewWebhooks(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Notify(Event{ID: "1", Type: ViolationStarted, Site: "north", Time: time.Now()})
+	select {
+	case event := <-received:
+		if event.ID != "1" {
+			t.Errorf("Expected event 1, but was %v.", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the event to be delivered after retrying.")
+	}
+
+	waitForEmptyOutbox(t, cfg.Outbox)
+}
+
+func TestUnfilteredWebhookSkipsPositions(t *testing.T) {
+	received := make(chan Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+
+	cfg := notifyConfig(t, config.Webhook{URL: server.URL, MaxDistance: 50})
+	w, err := NewWebhooks(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Sent every tick, positions pass the distance filter with no distance
+	w.Notify(Event{ID: "1", Type: DronePositions, Site: "north", Drones: []models.Drone{{SerialNumber: "SN-1"}}})
+	w.Notify(Event{ID: "2", Type: ViolationUpdated, Site: "north", ClosestDistance: 20})
+	w.Notify(Event{ID: "3", Type: ViolationStarted, Site: "north", ClosestDistance: 20})
+	select {
+	case event := <-received:
+		if event.ID != "3" {
+			t.Errorf("Expected only the started event, but was %v.", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the started event to be delivered.")
+	}
+
+	waitForEmptyOutbox(t, cfg.Outbox)
+	if len(received) != 0 {
+		t.Errorf("Expected no other deliveries, but got %d.", len(received))
+	}
+}
+
+func TestWebhookFilters(t *testing.T) {
+	cfg := config.Webhook{Events: []string{ViolationCloser}, Sites: []string{"north"}, MaxDistance: 50}
+	tests := []struct {
+		event    Event
+		expected bool
+	}{
+		{Event{Type: ViolationCloser, Site: "north", ClosestDistance: 25}, true},
+		{Event{Type: ViolationStarted, Site: "north", ClosestDistance: 25}, false},
+		{Event{Type: ViolationCloser, Site: "south", ClosestDistance: 25}, false},
+		{Event{Type: ViolationCloser, Site: "north", ClosestDistance: 75}, false},
+	}
+	for _, test := range tests {
+		if matches(cfg, test.event) != test.expected {
+			t.Errorf("Expected match of %v to be %v.", test.event, test.expected)
+		}
+	}
+}
+
+func TestWebhookOutboxSurvivesRestart(t *testing.T) {
+	var up atomic.Bool
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+
+	cfg := notifyConfig(t, config.Webhook{URL: server.URL, Secret: "secret"})
+	cfg.InitialBackoff = time.Hour
+	cfg.MaxBackoff = time.Hour
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	w, err := NewWebhooks(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Notify(Event{ID: "1", Type: ViolationStarted, Time: time.Now()})
+	// Wait for the first attempt to fail before stopping
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		pending, _ := w.outbox.pending()
+		if len(pending) == 1 && pending[0].Attempts == 1 {
+			break
+		}
+	}
+	w.Close()
+
+	up.Store(true)
+	w, err = NewWebhooks(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	select {
+	case event := <-received:
+		if event.ID != "1" {
+			t.Errorf("Expected event 1, but was %v.", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the event in the outbox to be delivered after restarting.")
+	}
+	waitForEmptyOutbox(t, cfg.Outbox)
+}
+
+func TestWebhookErase(t *testing.T) {
+	received := make(chan Event, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := notifyConfig(t, config.Webhook{URL: server.URL, Secret: "secret"})
+	cfg.InitialBackoff = 50 * time.Millisecond
+	cfg.MaxBackoff = 50 * time.Millisecond
+	w, err := NewWebhooks(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// P-1 is retrying when it is erased while P-2 waits behind it
+	w.Notify(Event{ID: "1", Type: ViolationStarted, Time: time.Now(), Pilot: pilot("P-1", "Ada")})
+	w.Notify(Event{ID: "2", Type: ViolationStarted, Time: time.Now().Add(time.Second), Pilot: pilot("P-2", "Bob")})
+	<-received
+	if erased := w.Erase(func(p models.Pilot) bool { return p.PilotID == "P-1" }); erased != 1 {
+		t.Errorf("Expected 1 delivery to be erased, but was %d.", erased)
+	}
+
+	select {
+	case event := <-received:
+		if event.ID != "2" {
+			t.Errorf("Expected the erased event to be skipped, but event %s was sent.", event.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the next event to be delivered after the erased one.")
+	}
+	pending, _ := w.outbox.pending()
+	for _, d := range pending {
+		if d.Event.Pilot.PilotID == "P-1" {
+			t.Errorf("Expected the erased delivery to be gone from the outbox, but was %v.", d)
+		}
+	}
+}
+
+func notifyConfig(t *testing.T, webhooks ...config.Webhook) config.Notify {
+	return config.Notify{
+		Outbox:         t.TempDir(),
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		MaxAttempts:    5,
+		Webhooks:       webhooks,
+	}
+}
+
+func waitForEmptyOutbox(t *testing.T, dir string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if entries, _ := os.ReadDir(dir); len(entries) == 0 {
+			return
+		}
+	}
+	t.Errorf("Expected the outbox to be empty after delivering.")
+}
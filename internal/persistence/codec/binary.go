@@ -0,0 +1,272 @@
+package codec
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Binary is a compact codec in the style of protocol buffers. A struct is a
+// sequence of fields, each a varint key of the field number and the wire
+// type followed by the value. Fields are numbered by their `binary` tag, or
+// by their position from 1 without one, so fields must only be added at the
+// end unless tagged. Zero values are left out and unknown fields are
+// skipped, which lets older and newer records decode.
+var Binary Codec = binaryCodec{}
+
+// Wire types of protocol buffers
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var binaryMarshaler = reflect.TypeFor[encoding.BinaryMarshaler]()
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+func (binaryCodec) Marshal(v any) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("binary codec encodes structs, not %s", rv.Type())
+	}
+	return appendMessage(nil, rv)
+}
+
+func (binaryCodec) Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("binary codec decodes into a pointer to a struct, not %T", v)
+	}
+	return readMessage(data, rv.Elem())
+}
+
+// fieldNumber returns the number of the struct field, 0 to leave it out
+func fieldNumber(f reflect.StructField, i int) (uint64, error) {
+	if !f.IsExported() {
+		return 0, nil
+	}
+	tag, ok := f.Tag.Lookup("binary")
+	if !ok {
+		return uint64(i + 1), nil
+	}
+	if tag == "-" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(tag, 10, 32)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("field %s has an invalid binary tag %q", f.Name, tag)
+	}
+	return n, nil
+}
+
+func appendMessage(data []byte, v reflect.Value) ([]byte, error) {
+	t := v.Type()
+	for i := range t.NumField() {
+		n, err := fieldNumber(t.Field(i), i)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 || v.Field(i).IsZero() {
+			continue
+		}
+		field := v.Field(i)
+		// Slices other than bytes repeat the field for every element
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+			for j := range field.Len() {
+				if data, err = appendField(data, n, field.Index(j)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if data, err = appendField(data, n, field); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func appendField(data []byte, n uint64, v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return data, nil
+		}
+		if !v.Type().Implements(binaryMarshaler) {
+			v = v.Elem()
+		}
+	}
+	if v.Type().Implements(binaryMarshaler) {
+		b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(binary.AppendUvarint(data, n<<3|wireBytes), b), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		value := uint64(0)
+		if v.Bool() {
+			value = 1
+		}
+		return binary.AppendUvarint(binary.AppendUvarint(data, n<<3|wireVarint), value), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(binary.AppendUvarint(data, n<<3|wireVarint), v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return binary.AppendUvarint(binary.AppendUvarint(data, n<<3|wireVarint), v.Uint()), nil
+	case reflect.Float32:
+		data = binary.AppendUvarint(data, n<<3|wireFixed32)
+		return binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		data = binary.AppendUvarint(data, n<<3|wireFixed64)
+		return binary.LittleEndian.AppendUint64(data, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendBytes(binary.AppendUvarint(data, n<<3|wireBytes), []byte(v.String())), nil
+	case reflect.Slice:
+		return appendBytes(binary.AppendUvarint(data, n<<3|wireBytes), v.Bytes()), nil
+	case reflect.Struct:
+		message, err := appendMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(binary.AppendUvarint(data, n<<3|wireBytes), message), nil
+	}
+	return nil, fmt.Errorf("binary codec does not support %s", v.Type())
+}
+
+func appendBytes(data, b []byte) []byte {
+	return append(binary.AppendUvarint(data, uint64(len(b))), b...)
+}
+
+var errTruncated = errors.New("binary value is truncated")
+
+func readMessage(data []byte, v reflect.Value) error {
+	t := v.Type()
+	fields := make(map[uint64]int, t.NumField())
+	for i := range t.NumField() {
+		n, err := fieldNumber(t.Field(i), i)
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			fields[n] = i
+		}
+	}
+
+	for len(data) > 0 {
+		key, size := binary.Uvarint(data)
+		if size <= 0 {
+			return errTruncated
+		}
+		data = data[size:]
+
+		var varint uint64
+		var payload []byte
+		switch key & 7 {
+		case wireVarint:
+			varint, size = binary.Uvarint(data)
+			if size <= 0 {
+				return errTruncated
+			}
+		case wireFixed64:
+			if size = 8; len(data) < size {
+				return errTruncated
+			}
+			payload = data[:size]
+		case wireFixed32:
+			if size = 4; len(data) < size {
+				return errTruncated
+			}
+			payload = data[:size]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return errTruncated
+			}
+			payload, size = data[n:n+int(length)], n+int(length)
+		default:
+			return fmt.Errorf("unknown wire type %d", key&7)
+		}
+		data = data[size:]
+
+		i, ok := fields[key>>3]
+		if !ok {
+			// Removed or added since, skipped
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+			element := reflect.New(field.Type().Elem()).Elem()
+			if err := readField(element, key&7, varint, payload); err != nil {
+				return fmt.Errorf("field %s: %w", t.Field(i).Name, err)
+			}
+			field.Set(reflect.Append(field, element))
+			continue
+		}
+		if err := readField(field, key&7, varint, payload); err != nil {
+			return fmt.Errorf("field %s: %w", t.Field(i).Name, err)
+		}
+	}
+	return nil
+}
+
+func readField(v reflect.Value, wire, varint uint64, payload []byte) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	u, unmarshaler := v.Addr().Interface().(encoding.BinaryUnmarshaler)
+	expected := uint64(wireBytes)
+	switch {
+	case unmarshaler:
+	case v.Kind() == reflect.Float32:
+		expected = wireFixed32
+	case v.Kind() == reflect.Float64:
+		expected = wireFixed64
+	case v.Kind() == reflect.Bool || v.CanInt() || v.CanUint():
+		expected = wireVarint
+	}
+	if wire != expected {
+		return fmt.Errorf("wire type %d does not match %s", wire, v.Type())
+	}
+
+	switch {
+	case unmarshaler:
+		return u.UnmarshalBinary(payload)
+	case v.Kind() == reflect.Bool:
+		v.SetBool(varint != 0)
+	case v.CanInt():
+		// Zigzag encoded by AppendVarint
+		v.SetInt(int64(varint>>1) ^ -int64(varint&1))
+	case v.CanUint():
+		v.SetUint(varint)
+	case v.Kind() == reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(payload))))
+	case v.Kind() == reflect.Float64:
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(payload)))
+	case v.Kind() == reflect.String:
+		v.SetString(string(payload))
+	case v.Kind() == reflect.Slice:
+		v.SetBytes(append([]byte(nil), payload...))
+	case v.Kind() == reflect.Struct:
+		return readMessage(payload, v)
+	default:
+		return fmt.Errorf("binary codec does not support %s", v.Type())
+	}
+	return nil
+}
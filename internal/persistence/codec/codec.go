@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+)
+
+// Codec turns stored values into bytes and back. Decoding must tolerate
+// fields added to or removed from the value since it was encoded.
+type Codec interface {
+	// Name identifies the codec in the envelope, at most 255 bytes
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSON Codec = jsonCodec{}
+	Gob  Codec = gobCodec{}
+)
+
+// Codecs are the codecs values can be decoded with, by name
+var Codecs = map[string]Codec{
+	JSON.Name():   JSON,
+	Gob.Name():    Gob,
+	Binary.Name(): Binary,
+}
+
+// Errors counts the values that failed to encode or decode, keyed by
+// operation and codec, e.g. "decode.json"
+var Errors = expvar.NewMap("persistence_codec_errors")
+
+// magic and version start every envelope. Values without them are gob,
+// which was written before envelopes were introduced.
+var magic = []byte("bnc")
+
+const version = 1
+
+// Encode marshals the value with the codec and wraps it in an envelope of
+// the magic, the version, the length of the codec name, the codec name and
+// the payload
+func Encode(c Codec, v any) ([]byte, error) {
+	payload, err := c.Marshal(v)
+	if err != nil {
+		Errors.Add("encode."+c.Name(), 1)
+		return nil, fmt.Errorf("encode %s: %w", c.Name(), err)
+	}
+
+	name := c.Name()
+	data := make([]byte, 0, len(magic)+2+len(name)+len(payload))
+	data = append(data, magic...)
+	data = append(data, version, byte(len(name)))
+	data = append(data, name...)
+	return append(data, payload...), nil
+}
+
+// Decode unmarshals the value with the codec named in its envelope, so that
+// values written before changing codecs can still be read
+func Decode(data []byte, v any) error {
+	c, payload, err := open(data)
+	if err != nil {
+		Errors.Add("decode.envelope", 1)
+		return err
+	}
+	if err := c.Unmarshal(payload, v); err != nil {
+		Errors.Add("decode."+c.Name(), 1)
+		return fmt.Errorf("decode %s: %w", c.Name(), err)
+	}
+	return nil
+}
+
+func open(data []byte) (Codec, []byte, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return Gob, data, nil
+	}
+	rest := data[len(magic):]
+	if len(rest) < 2 {
+		return nil, nil, errors.New("envelope is truncated")
+	}
+	if rest[0] != version {
+		return nil, nil, fmt.Errorf("unsupported envelope version %d", rest[0])
+	}
+	n := int(rest[1])
+	if len(rest) < 2+n {
+		return nil, nil, errors.New("envelope is truncated")
+	}
+	name := string(rest[2 : 2+n])
+	c, ok := Codecs[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, rest[2+n:], nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal ignores unknown fields and leaves missing ones at their zero
+// value
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
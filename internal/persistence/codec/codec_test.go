@@ -0,0 +1,152 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"expvar"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type v1 struct {
+	ID       string
+	Distance float64
+}
+
+// v2 is v1 after adding a field
+type v2 struct {
+	ID       string
+	Distance float64
+	Serial   string
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSON, Gob, Binary} {
+		data, err := Encode(c, v1{ID: "P-1", Distance: 42})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded v1
+		if err := Decode(data, &decoded); err != nil || decoded.ID != "P-1" || decoded.Distance != 42 {
+			t.Errorf("Expected %s to round trip, but was %v with %v.", c.Name(), decoded, err)
+		}
+	}
+}
+
+func TestAddedFields(t *testing.T) {
+	for _, c := range []Codec{JSON, Gob, Binary} {
+		old, _ := Encode(c, v1{ID: "P-1", Distance: 42})
+		var decoded v2
+		if err := Decode(old, &decoded); err != nil || decoded.ID != "P-1" || len(decoded.Serial) != 0 {
+			t.Errorf("Expected %s to decode an older record, but was %v with %v.", c.Name(), decoded, err)
+		}
+
+		// Rolling back reads the newer records too
+		newer, _ := Encode(c, v2{ID: "P-2", Serial: "SN-2"})
+		var rolledBack v1
+		if err := Decode(newer, &rolledBack); err != nil || rolledBack.ID != "P-2" {
+			t.Errorf("Expected %s to decode a newer record, but was %v with %v.", c.Name(), rolledBack, err)
+		}
+	}
+}
+
+func TestBinary(t *testing.T) {
+	type pilot struct {
+		Name    string
+		Created time.Time
+	}
+	type record struct {
+		ID       string
+		Distance float64
+		Count    int
+		Active   bool
+		Pilot    pilot
+		Serials  []string
+		Closest  *float64
+		Ratio    float32 `binary:"10"`
+		skipped  string
+	}
+	closest := -12.5
+	expected := record{
+		ID:       "P-1",
+		Distance: 42.5,
+		Count:    -3,
+		Active:   true,
+		Pilot:    pilot{Name: "Bob", Created: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)},
+		Serials:  []string{"SN-1", "SN-2"},
+		Closest:  &closest,
+		Ratio:    0.5,
+		skipped:  "left out",
+	}
+
+	data, err := Encode(Binary, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded record
+	if err := Decode(data, &decoded); err != nil {
+		t.Fatalf("Expected the record to decode, but got %v.", err)
+	}
+	expected.skipped = ""
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %v, but was %v.", expected, decoded)
+	}
+	if json, _ := Encode(JSON, expected); len(data) >= len(json) {
+		t.Errorf("Expected binary to be smaller than JSON, but was %d and %d bytes.", len(data), len(json))
+	}
+
+	// A field decoded as another type is an error, not a silent zero
+	type changed struct {
+		ID int
+	}
+	if err := Decode(data, &changed{}); err == nil || !strings.Contains(err.Error(), "field ID") {
+		t.Errorf("Expected a mismatched field to fail, but was %v.", err)
+	}
+	if err := Decode(data[:len(data)-3], &record{}); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("Expected a truncated value to fail, but was %v.", err)
+	}
+}
+
+func TestLegacyGob(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gob.NewEncoder(buf).Encode(v1{ID: "P-1"})
+
+	var decoded v1
+	if err := Decode(buf.Bytes(), &decoded); err != nil || decoded.ID != "P-1" {
+		t.Errorf("Expected a value without an envelope to decode as gob, but was %v with %v.", decoded, err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	before := counter("decode.json") + counter("decode.envelope")
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"unknown version", []byte("bnc\x09\x04json{}"), "unsupported envelope version 9"},
+		{"unknown codec", []byte("bnc\x01\x03xml<v1/>"), `unknown codec "xml"`},
+		{"truncated", []byte("bnc\x01\x10js"), "truncated"},
+		{"corrupt payload", []byte("bnc\x01\x04json{"), "decode json"},
+	}
+	for _, test := range tests {
+		var decoded v1
+		err := Decode(test.data, &decoded)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected %s to fail with %q, but was %v.", test.name, test.expected, err)
+		}
+	}
+	if after := counter("decode.json") + counter("decode.envelope"); after-before != int64(len(tests)) {
+		t.Errorf("Expected every failure to be counted, but %d were.", after-before)
+	}
+}
+
+func counter(key string) int64 {
+	if v := Errors.Get(key); v != nil {
+		return v.(*expvar.Int).Value()
+	}
+	return 0
+}
@@ -40,7 +40,7 @@ func (d *DataStore[T]) expire() {
 		select {
 		case <-d.destroy:
 			ticker.Stop()
-			break
+			return
 		case <-ticker.C:
 			now := time.Now().UTC()
 			d.mut.Lock()
@@ -55,8 +55,6 @@ func (d *DataStore[T]) expire() {
 				}
 			}
 			d.mut.Unlock()
-		default:
-			continue
 		}
 	}
 }
@@ -93,6 +91,25 @@ func (d *DataStore[T]) Upsert(id string, data T) {
 	}
 }
 
+// DeleteFunc removes every value matching and returns how many there were
+func (d *DataStore[T]) DeleteFunc(match func(T) bool) int {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	deleted := 0
+	for id, element := range d.registry {
+		if match(element.Value.(*ElementWithID[T]).data) {
+			d.queue.Remove(element)
+			delete(d.registry, id)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		d.dirty = true
+	}
+	return deleted
+}
+
 func (d *DataStore[T]) Destroy() {
 	d.destroy <- true
 }
@@ -108,9 +125,32 @@ func (d *DataStore[T]) AsSlice() []T {
 	return result
 }
 
+func (d *DataStore[T]) IDs() []string {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	result := make([]string, 0, d.queue.Len())
+	for element := d.queue.Front(); element != nil; element = element.Next() {
+		result = append(result, element.Value.(*ElementWithID[T]).id)
+	}
+	return result
+}
+
+// Values is AsSlice, values kept in memory never fail to decode
+func (d *DataStore[T]) Values() ([]T, error) {
+	return d.AsSlice(), nil
+}
+
 func (d *DataStore[T]) HasChanges() bool {
-	defer func() {
-		d.dirty = false
-	}()
-	return d.dirty
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	dirty := d.dirty
+	d.dirty = false
+	return dirty
+}
+
+func (d *DataStore[T]) SetTTL(ttl time.Duration) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.ttl = ttl
 }
@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reaktor-birdnest/internal/config"
+	"strings"
+)
+
+// magic starts every sealed value, values without it are plaintext written
+// before encryption was enabled
+var magic = []byte("bne1")
+
+// ErrUnknownKey is returned when a value was sealed with a key that is not
+// in the keyring
+var ErrUnknownKey = errors.New("value is sealed with an unknown key")
+
+// Keyring seals values with its current key and opens values sealed with
+// any of its keys
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// Load reads the keys of the configuration from their environment variables
+// and files. It returns nil when encryption is off.
+func Load(cfg config.Encryption) (*Keyring, error) {
+	if len(cfg.KeyID) == 0 {
+		return nil, nil
+	}
+
+	keys := make(map[string][]byte, len(cfg.Keys))
+	for _, k := range cfg.Keys {
+		var encoded string
+		if len(k.Env) != 0 {
+			value, ok := os.LookupEnv(k.Env)
+			if !ok {
+				return nil, fmt.Errorf("key %s: environment variable %s is not set", k.ID, k.Env)
+			}
+			encoded = value
+		} else {
+			data, err := os.ReadFile(k.File)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", k.ID, err)
+			}
+			encoded = string(data)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("key %s is not base64: %w", k.ID, err)
+		}
+		keys[k.ID] = key
+	}
+	return NewKeyring(cfg.KeyID, keys)
+}
+
+// NewKeyring creates a keyring sealing with the key named current. Every
+// key must be 32 bytes for AES-256.
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes, got %d", id, len(key))
+		}
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("key id %q must be 1 to 255 bytes", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := k.keys[current]; !ok {
+		return nil, fmt.Errorf("current key %s is not in the keyring", current)
+	}
+	return k, nil
+}
+
+// Seal encrypts the plaintext with the current key. The additional data,
+// e.g. the Redis key, must be the same when opening so that sealed values
+// cannot be moved to another key.
+//
+// The sealed value is magic, the length of the key id, the key id, the nonce
+// and the ciphertext.
+func (k *Keyring) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, 0, len(magic)+1+len(k.current)+len(nonce)+len(plaintext)+aead.Overhead())
+	sealed = append(sealed, magic...)
+	sealed = append(sealed, byte(len(k.current)))
+	sealed = append(sealed, k.current...)
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts a sealed value with the key it was sealed with
+func (k *Keyring) Open(sealed, additionalData []byte) ([]byte, error) {
+	id, rest, err := keyID(sealed)
+	if err != nil {
+		return nil, err
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("sealed value is truncated")
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// Current reports whether the value is sealed with the current key, values
+// that are not need to be re-encrypted after a rotation
+func (k *Keyring) Current(value []byte) bool {
+	id, _, err := keyID(value)
+	return err == nil && id == k.current
+}
+
+// IsSealed tells sealed values apart from plaintext ones
+func IsSealed(value []byte) bool {
+	return bytes.HasPrefix(value, magic)
+}
+
+func keyID(sealed []byte) (string, []byte, error) {
+	if !IsSealed(sealed) {
+		return "", nil, errors.New("value is not sealed")
+	}
+	rest := sealed[len(magic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return "", nil, errors.New("sealed value is truncated")
+	}
+	n := int(rest[0])
+	return string(rest[1 : 1+n]), rest[1+n:], nil
+}
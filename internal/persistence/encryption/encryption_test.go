@@ -0,0 +1,89 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reaktor-birdnest/internal/config"
+	"testing"
+)
+
+func TestSealAndOpen(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": key(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := keyring.Seal([]byte("pilot"), []byte("north:SN-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || bytes.Contains(sealed, []byte("pilot")) {
+		t.Errorf("Expected the value to be sealed, but was %q.", sealed)
+	}
+
+	opened, err := keyring.Open(sealed, []byte("north:SN-1"))
+	if err != nil || string(opened) != "pilot" {
+		t.Errorf("Expected to open the value, but was %q with %v.", opened, err)
+	}
+	if _, err := keyring.Open(sealed, []byte("north:SN-2")); err == nil {
+		t.Errorf("Expected a value moved to another key not to open.")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old, _ := NewKeyring("k1", map[string][]byte{"k1": key(1)})
+	sealed, _ := old.Seal([]byte("pilot"), nil)
+
+	rotated, err := NewKeyring("k2", map[string][]byte{"k1": key(1), "k2": key(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Current(sealed) {
+		t.Errorf("Expected a value sealed with the old key to need re-encrypting.")
+	}
+	if opened, err := rotated.Open(sealed, nil); err != nil || string(opened) != "pilot" {
+		t.Errorf("Expected the old key to still open the value, but was %q with %v.", opened, err)
+	}
+	resealed, _ := rotated.Seal([]byte("pilot"), nil)
+	if !rotated.Current(resealed) {
+		t.Errorf("Expected a value sealed after rotating to use the new key.")
+	}
+
+	retired, _ := NewKeyring("k2", map[string][]byte{"k2": key(2)})
+	if _, err := retired.Open(sealed, nil); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected a retired key to be unknown, but was %v.", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key(1))+"\n"), 0o600)
+	t.Setenv("TEST_BIRDNEST_KEY", base64.StdEncoding.EncodeToString(key(2)))
+
+	keyring, err := Load(config.Encryption{
+		KeyID: "env",
+		Keys:  []config.Key{{ID: "file", File: path}, {ID: "env", Env: "TEST_BIRDNEST_KEY"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromFile, _ := NewKeyring("file", map[string][]byte{"file": key(1)})
+	sealed, _ := fromFile.Seal([]byte("pilot"), nil)
+	if _, err := keyring.Open(sealed, nil); err != nil {
+		t.Errorf("Expected the key from the file to be loaded, but was %v.", err)
+	}
+
+	if keyring, err := Load(config.Encryption{}); keyring != nil || err != nil {
+		t.Errorf("Expected no keyring without a key id, but was %v with %v.", keyring, err)
+	}
+	if _, err := Load(config.Encryption{KeyID: "short", Keys: []config.Key{{ID: "short", File: path + "-missing"}}}); err == nil {
+		t.Errorf("Expected a missing key file to fail.")
+	}
+}
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
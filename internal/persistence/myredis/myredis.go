@@ -1,44 +1,59 @@
 package myredis
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v9"
-	"log"
+	"log/slog"
+	"reaktor-birdnest/internal/persistence/codec"
+	"reaktor-birdnest/internal/persistence/encryption"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
-var queueKey = "queue"
-
 type MyRedis[T any] struct {
-	done  chan bool
-	dirty atomic.Bool
-	ctx   context.Context
-	rdb   *redis.Client
-	ttl   time.Duration
+	done      chan bool
+	dirty     atomic.Bool
+	ctx       context.Context
+	rdb       *redis.Client
+	ttl       atomic.Int64
+	namespace string
+	// codec encodes new values, values are decoded with the codec named in
+	// their envelope
+	codec codec.Codec
+	// keyring encrypts the values when set
+	keyring atomic.Pointer[encryption.Keyring]
 }
 
-func New[T any](opt *redis.Options, ttl time.Duration) *MyRedis[T] {
+// New creates a store whose keys are all prefixed with namespace, so that
+// several stores can share one Redis database. Values are encoded with the
+// codec and encrypted with the keyring unless it is nil.
+func New[T any](opt *redis.Options, namespace string, ttl time.Duration, c codec.Codec, keyring *encryption.Keyring) (*MyRedis[T], error) {
 	ctx := context.Background()
 	rdb := redis.NewClient(opt)
 
-	rdb.FlushDB(ctx)
+	result := &MyRedis[T]{
+		rdb:       rdb,
+		ctx:       ctx,
+		done:      make(chan bool, 1),
+		namespace: namespace,
+		codec:     c,
+	}
+	result.SetTTL(ttl)
+	result.SetKeyring(keyring)
+
+	result.flush()
 
 	_, err := rdb.ConfigSet(ctx, "notify-keyspace-events", "KEA").Result()
 	if err != nil {
-		log.Panicf("unable to set keyspace events %v", err)
+		rdb.Close()
+		return nil, fmt.Errorf("unable to set keyspace events: %w", err)
 	}
 
-	p := rdb.PSubscribe(ctx, "__keyevent@0__:expired")
-	result := &MyRedis[T]{
-		rdb:  rdb,
-		ctx:  ctx,
-		done: make(chan bool, 1),
-		ttl:  ttl,
-	}
+	// Expiry events are published per database
+	p := rdb.PSubscribe(ctx, fmt.Sprintf("__keyevent@%d__:expired", opt.DB))
 
 	go func(stop <-chan bool) {
 		for {
@@ -48,68 +63,201 @@ func New[T any](opt *redis.Options, ttl time.Duration) *MyRedis[T] {
 			default:
 				msg, err := p.ReceiveMessage(ctx)
 				if err != nil {
-					fmt.Printf("error %v", err)
+					slog.Error("stopped receiving expiry events", "namespace", namespace, "err", err)
 					return
 				}
-				result.rdb.ZRem(result.ctx, queueKey, msg.String())
+				id, ok := strings.CutPrefix(msg.Payload, result.key(""))
+				if !ok {
+					continue
+				}
+				result.rdb.ZRem(result.ctx, result.queueKey(), id)
 				result.dirty.Store(true)
 			}
 
 		}
 	}(result.done)
 
-	return result
+	return result, nil
 }
 
+func (m *MyRedis[T]) key(id string) string {
+	return m.namespace + ":" + id
+}
+
+func (m *MyRedis[T]) queueKey() string {
+	return m.namespace + "/queue"
+}
+
+// Remove everything left over in this namespace from a previous run
+func (m *MyRedis[T]) flush() {
+	keys := []string{m.queueKey()}
+	iter := m.rdb.Scan(m.ctx, 0, m.key("*"), 0).Iterator()
+	for iter.Next(m.ctx) {
+		keys = append(keys, iter.Val())
+	}
+	m.rdb.Del(m.ctx, keys...)
+}
+
+// Get treats a value that fails to decode as missing, so that it is
+// written again, after logging the error
 func (m *MyRedis[T]) Get(id string) (T, bool) {
-	var result T
-	bs, err := m.rdb.Get(m.ctx, id).Bytes()
+	result, found, err := m.Lookup(id)
 	if err != nil {
-		return result, false
+		slog.Error("failed to decode value", "namespace", m.namespace, "id", id, "err", err)
 	}
+	return result, found
+}
 
-	r := bytes.NewReader(bs)
-	err = gob.NewDecoder(r).Decode(&result)
+// Lookup returns the value and whether it was found, with the error when it
+// was found but failed to decode
+func (m *MyRedis[T]) Lookup(id string) (T, bool, error) {
+	var result T
+	bs, err := m.rdb.Get(m.ctx, m.key(id)).Bytes()
 	if err != nil {
-		return result, false
+		return result, false, nil
 	}
-
-	return result, true
+	result, err = m.decode(m.key(id), bs)
+	if err != nil {
+		return result, false, fmt.Errorf("value %s: %w", id, err)
+	}
+	return result, true, nil
 }
 
 func (m *MyRedis[T]) Upsert(id string, data T) {
-	buf := bytes.Buffer{}
-	gob.NewEncoder(&buf).Encode(data)
+	value, err := m.encode(m.key(id), data)
+	if err != nil {
+		slog.Error("failed to encode value", "namespace", m.namespace, "id", id, "err", err)
+		return
+	}
 
-	m.rdb.Set(m.ctx, id, buf.Bytes(), m.ttl)
-	m.rdb.ZAdd(m.ctx, queueKey, redis.Z{
+	m.rdb.Set(m.ctx, m.key(id), value, time.Duration(m.ttl.Load()))
+	m.rdb.ZAdd(m.ctx, m.queueKey(), redis.Z{
 		Member: id,
 		Score:  float64(time.Now().UTC().Unix()),
 	})
 	m.dirty.Store(true)
 }
 
+// DeleteFunc removes every value matching and returns how many there were
+func (m *MyRedis[T]) DeleteFunc(match func(T) bool) int {
+	deleted := 0
+	for _, id := range m.rdb.ZRange(m.ctx, m.queueKey(), 0, -1).Val() {
+		value, found := m.Get(id)
+		if !found || !match(value) {
+			continue
+		}
+		m.rdb.Del(m.ctx, m.key(id))
+		m.rdb.ZRem(m.ctx, m.queueKey(), id)
+		deleted++
+	}
+	if deleted > 0 {
+		m.dirty.Store(true)
+	}
+	return deleted
+}
+
 func (m *MyRedis[T]) Destroy() {
 	m.rdb.Close()
 }
 
+// AsSlice leaves out the values that fail to decode after logging the error
 func (m *MyRedis[T]) AsSlice() []T {
-	queue := m.rdb.ZRevRange(m.ctx, queueKey, 0, -1).Val()
-	violationBuffers := m.rdb.MGet(m.ctx, queue...).Val()
+	result, err := m.Values()
+	if err != nil {
+		slog.Error("failed to decode values", "namespace", m.namespace, "err", err)
+	}
+	return result
+}
+
+// Values returns the values newest first like AsSlice, and those that failed
+// to decode as an error
+func (m *MyRedis[T]) Values() ([]T, error) {
+	queue := m.rdb.ZRevRange(m.ctx, m.queueKey(), 0, -1).Val()
+	if len(queue) == 0 {
+		return []T{}, nil
+	}
+	keys := make([]string, 0, len(queue))
+	for _, id := range queue {
+		keys = append(keys, m.key(id))
+	}
+	violationBuffers := m.rdb.MGet(m.ctx, keys...).Val()
 	result := make([]T, 0, len(violationBuffers))
-	for _, violationBuffer := range violationBuffers {
+	var errs []error
+	for i, violationBuffer := range violationBuffers {
 		if violationBuffer == nil {
 			continue
 		}
-		var decoded T
-		reader := bytes.NewReader([]byte(violationBuffer.(string)))
-		gob.NewDecoder(reader).Decode(&decoded)
+		decoded, err := m.decode(keys[i], []byte(violationBuffer.(string)))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("value %s: %w", queue[i], err))
+			continue
+		}
 		result = append(result, decoded)
 	}
 
-	return result
+	return result, errors.Join(errs...)
+}
+
+// IDs returns the ids in the queue, which may still list a value that has
+// just expired until its expiry event arrives
+func (m *MyRedis[T]) IDs() []string {
+	return m.rdb.ZRevRange(m.ctx, m.queueKey(), 0, -1).Val()
 }
 
 func (m *MyRedis[T]) HasChanges() bool {
 	return m.dirty.Swap(false)
 }
+
+// encode wraps the data in a codec envelope and encrypts it for the Redis key
+func (m *MyRedis[T]) encode(key string, data T) ([]byte, error) {
+	value, err := codec.Encode(m.codec, data)
+	if err != nil {
+		return nil, err
+	}
+	return m.seal(key, value)
+}
+
+// decode decrypts the value of the Redis key and decodes it with the codec
+// it was written with
+func (m *MyRedis[T]) decode(key string, value []byte) (T, error) {
+	var result T
+	value, err := m.open(key, value)
+	if err != nil {
+		return result, err
+	}
+	err = codec.Decode(value, &result)
+	return result, err
+}
+
+// seal encrypts the value of the Redis key when encryption is on
+func (m *MyRedis[T]) seal(key string, value []byte) ([]byte, error) {
+	keyring := m.keyring.Load()
+	if keyring == nil {
+		return value, nil
+	}
+	return keyring.Seal(value, []byte(key))
+}
+
+// open decrypts the value of the Redis key. Plaintext values written before
+// encryption was turned on are returned as they are.
+func (m *MyRedis[T]) open(key string, value []byte) ([]byte, error) {
+	if !encryption.IsSealed(value) {
+		return value, nil
+	}
+	keyring := m.keyring.Load()
+	if keyring == nil {
+		return nil, errors.New("value is encrypted but encryption is off")
+	}
+	return keyring.Open(value, []byte(key))
+}
+
+// SetKeyring changes the keys values are encrypted and decrypted with, nil
+// stores new values in plaintext
+func (m *MyRedis[T]) SetKeyring(keyring *encryption.Keyring) {
+	m.keyring.Store(keyring)
+}
+
+// SetTTL changes the expiry of values upserted from now on
+func (m *MyRedis[T]) SetTTL(ttl time.Duration) {
+	m.ttl.Store(int64(ttl))
+}
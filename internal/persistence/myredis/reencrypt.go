@@ -0,0 +1,58 @@
+package myredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v9"
+	"reaktor-birdnest/internal/persistence/encryption"
+)
+
+// Reencrypt seals every value of the namespace that is plaintext or sealed
+// with an older key with the current key of the keyring, keeping its TTL. It
+// is safe to run while the application writes to the namespace and returns
+// how many values were re-encrypted.
+func Reencrypt(ctx context.Context, rdb *redis.Client, namespace string, keyring *encryption.Keyring) (int, error) {
+	migrated := 0
+	iter := rdb.Scan(ctx, 0, namespace+":*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+			value, err := tx.Get(ctx, key).Bytes()
+			if errors.Is(err, redis.Nil) {
+				// Expired since scanning
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if keyring.Current(value) {
+				return nil
+			}
+
+			plaintext := value
+			if encryption.IsSealed(value) {
+				if plaintext, err = keyring.Open(value, []byte(key)); err != nil {
+					return err
+				}
+			}
+			sealed, err := keyring.Seal(plaintext, []byte(key))
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.SetArgs(ctx, key, sealed, redis.SetArgs{KeepTTL: true})
+				return nil
+			})
+			if err == nil {
+				migrated++
+			}
+			return err
+		}, key)
+		// A value written meanwhile is already sealed with the current key
+		if err != nil && !errors.Is(err, redis.TxFailedErr) {
+			return migrated, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return migrated, iter.Err()
+}
@@ -0,0 +1,52 @@
+package myredis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v9"
+	"reaktor-birdnest/internal/rollup"
+)
+
+// Rollups keeps the closed buckets of a site in a Redis list per resolution,
+// which survives the flush of the namespace's violations on startup
+type Rollups struct {
+	rdb       *redis.Client
+	namespace string
+}
+
+func NewRollups(opt *redis.Options, namespace string) *Rollups {
+	return &Rollups{rdb: redis.NewClient(opt), namespace: namespace}
+}
+
+func (r *Rollups) key(resolution string) string {
+	return r.namespace + "/rollups/" + resolution
+}
+
+func (r *Rollups) Load(resolution string, keep int) ([]rollup.Bucket, error) {
+	values, err := r.rdb.LRange(context.Background(), r.key(resolution), int64(-keep), -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]rollup.Bucket, 0, len(values))
+	for _, value := range values {
+		b, err := rollup.Decode([]byte(value))
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+func (r *Rollups) Append(resolution string, b rollup.Bucket, keep int) error {
+	ctx := context.Background()
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, r.key(resolution), rollup.Encode(b))
+		pipe.LTrim(ctx, r.key(resolution), int64(-keep), -1)
+		return nil
+	})
+	return err
+}
+
+func (r *Rollups) Close() error {
+	return r.rdb.Close()
+}
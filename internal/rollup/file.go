@@ -0,0 +1,111 @@
+package rollup
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File keeps the closed buckets of a site in a file per resolution of 24
+// bytes per bucket, which is rewritten with the latest buckets when it
+// holds twice as many as are kept
+type File struct {
+	dir, site string
+
+	mut sync.Mutex
+	// counts are the buckets in each file
+	counts map[string]int
+}
+
+// OpenFile keeps the buckets of the site in dir, which is created if needed
+func OpenFile(dir, site string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir, site: site, counts: make(map[string]int)}, nil
+}
+
+func (f *File) path(resolution string) string {
+	return filepath.Join(f.dir, f.site+"-"+resolution+".bin")
+}
+
+func (f *File) Load(resolution string, keep int) ([]Bucket, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	buckets, err := f.read(resolution)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(buckets) > keep {
+		buckets = buckets[len(buckets)-keep:]
+	}
+	return buckets, f.rewrite(resolution, buckets)
+}
+
+func (f *File) Append(resolution string, b Bucket, keep int) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	if f.counts[resolution]+1 > 2*keep {
+		buckets, err := f.read(resolution)
+		if err != nil {
+			return err
+		}
+		buckets = append(buckets, b)
+		return f.rewrite(resolution, buckets[max(0, len(buckets)-keep):])
+	}
+
+	file, err := os.OpenFile(f.path(resolution), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(Encode(b)); err != nil {
+		return err
+	}
+	f.counts[resolution]++
+	return nil
+}
+
+// read returns every bucket in the file, the caller must hold f.mut
+func (f *File) read(resolution string) ([]Bucket, error) {
+	data, err := os.ReadFile(f.path(resolution))
+	if err != nil {
+		return nil, err
+	}
+	// A bucket cut short by a crash is dropped
+	data = data[:len(data)-len(data)%size]
+	buckets := make([]Bucket, 0, len(data)/size)
+	for i := 0; i < len(data); i += size {
+		b, err := Decode(data[i : i+size])
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// rewrite replaces the file with the buckets, the caller must hold f.mut
+func (f *File) rewrite(resolution string, buckets []Bucket) error {
+	data := make([]byte, 0, len(buckets)*size)
+	for _, b := range buckets {
+		data = append(data, Encode(b)...)
+	}
+	tmp := f.path(resolution) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.path(resolution)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	f.counts[resolution] = len(buckets)
+	return nil
+}
@@ -0,0 +1,197 @@
+package rollup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reaktor-birdnest/internal/models"
+	"sync"
+	"time"
+)
+
+// The resolutions of the series
+const (
+	Minute = "minute"
+	Hour   = "hour"
+	Day    = "day"
+)
+
+// Resolutions are the lengths of the buckets by resolution, days are UTC
+var Resolutions = map[string]time.Duration{
+	Minute: time.Minute,
+	Hour:   time.Hour,
+	Day:    24 * time.Hour,
+}
+
+// Bucket is what the sensor saw in a minute, hour or day
+type Bucket struct {
+	Start time.Time `json:"start"`
+	// Drones is the most drones in a single report
+	Drones int `json:"drones"`
+	// Serials is the number of distinct drones
+	Serials    int `json:"serials"`
+	Violations int `json:"violations"`
+	// MinDistance is the closest any drone came to a zone origin in meters,
+	// null without drones
+	MinDistance *float64 `json:"minDistance"`
+}
+
+// Store keeps the closed buckets of a site
+type Store interface {
+	// Load returns the latest keep buckets of the resolution, oldest first
+	Load(resolution string, keep int) ([]Bucket, error)
+	// Append adds a closed bucket, the store may drop all but the latest
+	// keep buckets
+	Append(resolution string, b Bucket, keep int) error
+}
+
+// Rollups counts the drones and violations of a site per minute, hour and
+// day. Closed buckets are kept in the store, the current ones only in
+// memory.
+type Rollups struct {
+	store Store
+	keep  map[string]int
+
+	mut    sync.RWMutex
+	closed map[string][]Bucket
+	open   map[string]*openBucket
+}
+
+type openBucket struct {
+	Bucket
+	serials map[string]bool
+}
+
+// New loads the closed buckets from the store, keeping as many of each
+// resolution as given by keep
+func New(store Store, keep map[string]int) (*Rollups, error) {
+	r := &Rollups{
+		store:  store,
+		keep:   keep,
+		closed: make(map[string][]Bucket, len(Resolutions)),
+		open:   make(map[string]*openBucket, len(Resolutions)),
+	}
+	for resolution := range Resolutions {
+		buckets, err := store.Load(resolution, keep[resolution])
+		if err != nil {
+			return nil, fmt.Errorf("load %s rollups: %w", resolution, err)
+		}
+		r.closed[resolution] = buckets
+	}
+	return r, nil
+}
+
+// Add counts a report and the violations started with it. Buckets closed by
+// it are appended to the store, an error is returned when that fails.
+func (r *Rollups) Add(zones []models.Zone, drones []models.Drone, violations int, now time.Time) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var errs []error
+	for resolution, length := range Resolutions {
+		start := now.Truncate(length)
+		b := r.open[resolution]
+		if b != nil && !b.Start.Equal(start) {
+			if err := r.close(resolution, b.Bucket); err != nil {
+				errs = append(errs, err)
+			}
+			b = nil
+		}
+		if b == nil {
+			b = &openBucket{Bucket: Bucket{Start: start}, serials: make(map[string]bool)}
+			r.open[resolution] = b
+		}
+
+		b.Drones = max(b.Drones, len(drones))
+		b.Violations += violations
+		for _, drone := range drones {
+			b.serials[drone.SerialNumber] = true
+			for _, zone := range zones {
+				d := zone.Distance(drone)
+				if b.MinDistance == nil || d < *b.MinDistance {
+					b.MinDistance = &d
+				}
+			}
+		}
+		b.Serials = len(b.serials)
+	}
+	return errors.Join(errs...)
+}
+
+// close keeps the bucket, the caller must hold r.mut
+func (r *Rollups) close(resolution string, b Bucket) error {
+	keep := r.keep[resolution]
+	buckets := append(r.closed[resolution], b)
+	if len(buckets) > keep {
+		buckets = buckets[len(buckets)-keep:]
+	}
+	r.closed[resolution] = buckets
+	if err := r.store.Append(resolution, b, keep); err != nil {
+		return fmt.Errorf("store %s rollup: %w", resolution, err)
+	}
+	return nil
+}
+
+// Series returns the buckets of the resolution oldest first, the last one is
+// the current bucket
+func (r *Rollups) Series(resolution string) []Bucket {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	closed := r.closed[resolution]
+	series := make([]Bucket, len(closed), len(closed)+1)
+	copy(series, closed)
+	if b, ok := r.open[resolution]; ok {
+		series = append(series, b.Bucket)
+	}
+	return series
+}
+
+// Memory keeps the closed buckets in memory only, which Rollups does anyway
+type Memory struct{}
+
+func (Memory) Load(string, int) ([]Bucket, error) {
+	return nil, nil
+}
+
+func (Memory) Append(string, Bucket, int) error {
+	return nil
+}
+
+// size of an encoded bucket
+const size = 24
+
+// Encode packs the bucket into 24 bytes: the start in Unix seconds, the
+// counts and the distance as a float32, NaN when there is none
+func Encode(b Bucket) []byte {
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint64(data[0:], uint64(b.Start.Unix()))
+	binary.LittleEndian.PutUint32(data[8:], uint32(b.Drones))
+	binary.LittleEndian.PutUint32(data[12:], uint32(b.Serials))
+	binary.LittleEndian.PutUint32(data[16:], uint32(b.Violations))
+	distance := float32(math.NaN())
+	if b.MinDistance != nil {
+		distance = float32(*b.MinDistance)
+	}
+	binary.LittleEndian.PutUint32(data[20:], math.Float32bits(distance))
+	return data
+}
+
+// Decode unpacks a bucket packed by Encode
+func Decode(data []byte) (Bucket, error) {
+	if len(data) != size {
+		return Bucket{}, fmt.Errorf("encoded bucket is %d bytes, expected %d", len(data), size)
+	}
+	b := Bucket{
+		Start:      time.Unix(int64(binary.LittleEndian.Uint64(data[0:])), 0).UTC(),
+		Drones:     int(binary.LittleEndian.Uint32(data[8:])),
+		Serials:    int(binary.LittleEndian.Uint32(data[12:])),
+		Violations: int(binary.LittleEndian.Uint32(data[16:])),
+	}
+	if distance := math.Float32frombits(binary.LittleEndian.Uint32(data[20:])); !math.IsNaN(float64(distance)) {
+		d := float64(distance)
+		b.MinDistance = &d
+	}
+	return b, nil
+}
@@ -0,0 +1,76 @@
+package rollup
+
+import (
+	"os"
+	"reaktor-birdnest/internal/models"
+	"testing"
+	"time"
+)
+
+var zones = []models.Zone{{OriginX: 250000, OriginY: 250000, Radius: 100}}
+
+func TestRollups(t *testing.T) {
+	r, err := New(Memory{}, map[string]int{Minute: 2, Hour: 10, Day: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	r.Add(zones, []models.Drone{drone("SN-1", 100), drone("SN-2", 200)}, 1, start)
+	r.Add(zones, []models.Drone{drone("SN-1", 150)}, 0, start.Add(30*time.Second))
+	r.Add(zones, nil, 2, start.Add(time.Minute))
+
+	hours := r.Series(Hour)
+	if len(hours) != 1 {
+		t.Fatalf("Expected the current hour only, but was %v.", hours)
+	}
+	if b := hours[0]; !b.Start.Equal(start) || b.Drones != 2 || b.Serials != 2 || b.Violations != 3 || *b.MinDistance != 100 {
+		t.Errorf("Expected 2 drones, 2 serials, 3 violations and 100 m, but was %v.", b)
+	}
+	minutes := r.Series(Minute)
+	if len(minutes) != 2 || minutes[0].Violations != 1 || minutes[1].Drones != 0 || minutes[1].MinDistance != nil {
+		t.Errorf("Expected a closed and an empty current minute, but was %v.", minutes)
+	}
+
+	// Only the latest 2 closed minutes are kept
+	r.Add(zones, nil, 0, start.Add(2*time.Minute))
+	r.Add(zones, nil, 0, start.Add(3*time.Minute))
+	if minutes := r.Series(Minute); len(minutes) != 3 || !minutes[0].Start.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the oldest minute to be dropped, but was %v.", minutes)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	f, err := OpenFile(dir, "north")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	distance := 42.5
+	for i := range 5 {
+		if err := f.Append(Hour, Bucket{Start: start.Add(time.Duration(i) * time.Hour), Drones: i, MinDistance: &distance}, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rewritten with the latest 2 when the fifth bucket was appended
+	if info, _ := os.Stat(f.path(Hour)); info.Size() != 2*size {
+		t.Errorf("Expected the file to be compacted, but was %d bytes.", info.Size())
+	}
+
+	f, _ = OpenFile(dir, "north")
+	buckets, err := f.Load(Hour, 2)
+	if err != nil || len(buckets) != 2 {
+		t.Fatalf("Expected 2 buckets, but was %v with %v.", buckets, err)
+	}
+	if b := buckets[1]; !b.Start.Equal(start.Add(4*time.Hour)) || b.Drones != 4 || *b.MinDistance != distance {
+		t.Errorf("Expected the latest bucket to be decoded, but was %v.", b)
+	}
+	if buckets, err := f.Load(Day, 2); err != nil || len(buckets) != 0 {
+		t.Errorf("Expected no days, but was %v with %v.", buckets, err)
+	}
+}
+
+func drone(serial string, distance float64) models.Drone {
+	return models.Drone{SerialNumber: serial, PositionX: 250000 + distance*1000, PositionY: 250000}
+}
@@ -0,0 +1,86 @@
+package stats
+
+import (
+	"cmp"
+	"reaktor-birdnest/internal/history"
+	"slices"
+	"strconv"
+)
+
+// The groupings of Drones
+const (
+	ByManufacturer = "manufacturer"
+	ByModel        = "model"
+	ByFirmware     = "firmware"
+)
+
+// Breakdown sums up the violations of the drones of a manufacturer, model or
+// firmware. The fields finer than the grouping are empty.
+type Breakdown struct {
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model,omitempty"`
+	Firmware     string `json:"firmware,omitempty"`
+	// Violations counts those the group's drones were flown in
+	Violations int `json:"violations"`
+	// Drones counts the distinct serials, anonymised episodes count as one
+	// drone per violation
+	Drones int `json:"drones"`
+	// TimeInZone in seconds over every episode
+	TimeInZone      float64 `json:"timeInZone"`
+	ClosestDistance float64 `json:"closestDistance"`
+}
+
+// Drones groups the episodes of the records by the manufacturer, model or
+// firmware of their drone, the most violations first. Anonymised records are
+// included as they no longer identify the pilot.
+func Drones(records []history.Record, by string) []Breakdown {
+	groups := make(map[Breakdown]*Breakdown)
+	serials := make(map[Breakdown]map[string]bool)
+	for i, r := range records {
+		counted := make(map[Breakdown]bool)
+		for _, e := range r.Episodes {
+			key := Breakdown{Manufacturer: e.Manufacturer}
+			switch by {
+			case ByFirmware:
+				key.Firmware = e.Firmware
+				fallthrough
+			case ByModel:
+				key.Model = e.Model
+			}
+
+			g, ok := groups[key]
+			if !ok {
+				g = &Breakdown{Manufacturer: key.Manufacturer, Model: key.Model, Firmware: key.Firmware, ClosestDistance: e.ClosestDistance}
+				groups[key] = g
+				serials[key] = make(map[string]bool)
+			}
+			if !counted[key] {
+				counted[key] = true
+				g.Violations++
+			}
+			serial := e.Serial
+			if len(serial) == 0 {
+				serial = "anonymised/" + strconv.Itoa(i)
+			}
+			serials[key][serial] = true
+			g.TimeInZone += e.Duration
+			g.ClosestDistance = min(g.ClosestDistance, e.ClosestDistance)
+		}
+	}
+
+	breakdown := make([]Breakdown, 0, len(groups))
+	for key, g := range groups {
+		g.Drones = len(serials[key])
+		breakdown = append(breakdown, *g)
+	}
+	slices.SortFunc(breakdown, func(a, b Breakdown) int {
+		return cmp.Or(
+			cmp.Compare(b.Violations, a.Violations),
+			cmp.Compare(b.TimeInZone, a.TimeInZone),
+			cmp.Compare(a.Manufacturer, b.Manufacturer),
+			cmp.Compare(a.Model, b.Model),
+			cmp.Compare(a.Firmware, b.Firmware),
+		)
+	})
+	return breakdown
+}
@@ -0,0 +1,37 @@
+package stats
+
+import (
+	"reaktor-birdnest/internal/history"
+	"reaktor-birdnest/internal/models"
+	"testing"
+)
+
+func TestDrones(t *testing.T) {
+	mavic := func(serial, firmware string, duration float64) models.Episode {
+		return models.Episode{Serial: serial, Manufacturer: "DJI", Model: "Mavic", Firmware: firmware, Duration: duration, ClosestDistance: duration}
+	}
+	records := []history.Record{
+		// Two episodes of one violation count once
+		{Pilot: models.Pilot{PilotID: "P-1"}, Episodes: []models.Episode{mavic("SN-1", "1.0", 10), mavic("SN-1", "1.0", 20)}},
+		{Pilot: models.Pilot{PilotID: "P-2"}, Episodes: []models.Episode{mavic("SN-2", "1.1", 5)}},
+		{Anonymised: true, Episodes: []models.Episode{mavic("", "1.1", 5)}},
+		{Pilot: models.Pilot{PilotID: "P-3"}, Episodes: []models.Episode{{Serial: "SN-3", Manufacturer: "DJI", Model: "Mini", Duration: 50, ClosestDistance: 3}}},
+	}
+
+	byModel := Drones(records, ByModel)
+	if len(byModel) != 2 {
+		t.Fatalf("Expected 2 models, but was %v.", byModel)
+	}
+	if b := byModel[0]; b.Model != "Mavic" || b.Violations != 3 || b.Drones != 3 || b.TimeInZone != 40 || b.ClosestDistance != 5 {
+		t.Errorf("Expected the Mavic first with 3 violations of 3 drones, but was %v.", b)
+	}
+
+	byManufacturer := Drones(records, ByManufacturer)
+	if len(byManufacturer) != 1 || byManufacturer[0].Violations != 4 || byManufacturer[0].Model != "" {
+		t.Errorf("Expected every violation under DJI, but was %v.", byManufacturer)
+	}
+	byFirmware := Drones(records, ByFirmware)
+	if len(byFirmware) != 3 || byFirmware[0].Firmware != "1.1" || byFirmware[0].Violations != 2 {
+		t.Errorf("Expected firmware 1.1 first with 2 violations, but was %v.", byFirmware)
+	}
+}
@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"cmp"
+	"reaktor-birdnest/internal/history"
+	"reaktor-birdnest/internal/models"
+	"slices"
+	"time"
+)
+
+// Pilot sums up every violation of a pilot
+type Pilot struct {
+	// Pilot has the contact details of the latest violation
+	Pilot      models.Pilot `json:"pilot"`
+	Violations int          `json:"violations"`
+	// TimeInZone is the seconds the pilot's drones spent inside the zones
+	// over every episode
+	TimeInZone      float64 `json:"timeInZone"`
+	ClosestDistance float64 `json:"closestDistance"`
+	// FirstOffence and LastOffence are when the first and the latest
+	// violation started, zero when only violations not archived yet are
+	// known
+	FirstOffence time.Time `json:"firstOffence"`
+	LastOffence  time.Time `json:"lastOffence"`
+	// Drones are the serial numbers of the drones flown into the zones
+	Drones []string `json:"drones"`
+}
+
+// Pilots groups the records by pilot id, anonymised records are left out.
+// The pilots with the most violations come first, ties are broken by the
+// time in the zones.
+func Pilots(records []history.Record) []Pilot {
+	byID := make(map[string]*Pilot)
+	for _, r := range records {
+		if r.Anonymised || len(r.Pilot.PilotID) == 0 {
+			continue
+		}
+		p, ok := byID[r.Pilot.PilotID]
+		if !ok {
+			p = &Pilot{Pilot: r.Pilot, ClosestDistance: r.ClosestDistance, Drones: make([]string, 0)}
+			byID[r.Pilot.PilotID] = p
+		}
+		p.Violations++
+		p.ClosestDistance = min(p.ClosestDistance, r.ClosestDistance)
+		for _, e := range r.Episodes {
+			p.TimeInZone += e.Duration
+			if len(e.Serial) != 0 && !slices.Contains(p.Drones, e.Serial) {
+				p.Drones = append(p.Drones, e.Serial)
+			}
+		}
+		if r.Start.IsZero() {
+			continue
+		}
+		if p.FirstOffence.IsZero() || r.Start.Before(p.FirstOffence) {
+			p.FirstOffence = r.Start
+		}
+		if !r.Start.Before(p.LastOffence) {
+			p.LastOffence = r.Start
+			p.Pilot = r.Pilot
+		}
+	}
+
+	pilots := make([]Pilot, 0, len(byID))
+	for _, p := range byID {
+		slices.Sort(p.Drones)
+		pilots = append(pilots, *p)
+	}
+	slices.SortFunc(pilots, func(a, b Pilot) int {
+		return cmp.Or(
+			cmp.Compare(b.Violations, a.Violations),
+			cmp.Compare(b.TimeInZone, a.TimeInZone),
+			cmp.Compare(a.ClosestDistance, b.ClosestDistance),
+			cmp.Compare(a.Pilot.PilotID, b.Pilot.PilotID),
+		)
+	})
+	return pilots
+}
@@ -0,0 +1,44 @@
+package stats
+
+import (
+	"reaktor-birdnest/internal/history"
+	"reaktor-birdnest/internal/models"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPilots(t *testing.T) {
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	bob := models.Pilot{PilotID: "P-1", FirstName: "Bob"}
+	renamed := models.Pilot{PilotID: "P-1", FirstName: "Robert"}
+	alice := models.Pilot{PilotID: "P-2", FirstName: "Alice"}
+	records := []history.Record{
+		{Site: "north", Pilot: bob, ClosestDistance: 60, Start: start, Episodes: []models.Episode{
+			{Serial: "SN-1", Duration: 30},
+			{Serial: "SN-1", Duration: 10},
+		}},
+		{Site: "north", Pilot: alice, ClosestDistance: 20, Start: start.Add(time.Hour), Episodes: []models.Episode{{Serial: "SN-3", Duration: 5}}},
+		// Bob with another drone under newer contact details
+		{Site: "south", Pilot: renamed, ClosestDistance: 40, Start: start.Add(2 * time.Hour), Episodes: []models.Episode{{Serial: "SN-2", Duration: 20}}},
+		// Restored without a start
+		{Site: "north", Pilot: alice, ClosestDistance: 80},
+		{Site: "north", ClosestDistance: 1, Anonymised: true},
+	}
+
+	pilots := Pilots(records)
+	if len(pilots) != 2 {
+		t.Fatalf("Expected 2 pilots, but was %v.", pilots)
+	}
+	// Tied on violations, Bob spent longer in the zones
+	p := pilots[0]
+	if p.Pilot != renamed || p.Violations != 2 || p.TimeInZone != 60 || p.ClosestDistance != 40 {
+		t.Errorf("Expected Robert first with 2 violations, 60 s and 40 m, but was %v.", p)
+	}
+	if !p.FirstOffence.Equal(start) || !p.LastOffence.Equal(start.Add(2*time.Hour)) || !slices.Equal(p.Drones, []string{"SN-1", "SN-2"}) {
+		t.Errorf("Expected Robert's offences and both drones, but was %v.", p)
+	}
+	if p := pilots[1]; p.Pilot != alice || p.Violations != 2 || !p.FirstOffence.Equal(start.Add(time.Hour)) || !p.LastOffence.Equal(p.FirstOffence) {
+		t.Errorf("Expected Alice second with the start of the archived violation, but was %v.", p)
+	}
+}
@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"net/url"
+	"reaktor-birdnest/internal/config"
+)
+
+// tracesPath is where OTLP/HTTP collectors receive spans
+const tracesPath = "/v1/traces"
+
+// Setup installs a global tracer provider exporting spans over OTLP/HTTP to
+// the configured endpoint. Without an endpoint the default no-op provider is
+// kept. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg config.Tracing) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if len(cfg.Endpoint) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpointURL(cfg.Endpoint)))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// endpointURL adds the traces path to an endpoint given as just the
+// collector's address, e.g. http://localhost:4318, which the exporter would
+// otherwise post to as is
+func endpointURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || (len(u.Path) != 0 && u.Path != "/") {
+		return endpoint
+	}
+	u.Path = tracesPath
+	return u.String()
+}
@@ -0,0 +1,15 @@
+package tracing
+
+import "testing"
+
+func TestEndpointURL(t *testing.T) {
+	for endpoint, expected := range map[string]string{
+		"http://localhost:4318":                    "http://localhost:4318/v1/traces",
+		"http://localhost:4318/":                   "http://localhost:4318/v1/traces",
+		"https://collector.example/otlp/v1/traces": "https://collector.example/otlp/v1/traces",
+	} {
+		if actual := endpointURL(endpoint); actual != expected {
+			t.Errorf("Expected %s to post to %s, but was %s.", endpoint, expected, actual)
+		}
+	}
+}